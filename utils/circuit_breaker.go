@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	//CircuitOpenError error
+	CircuitOpenError = status.Error(codes.Unavailable, "SYS.MSG.CIRCUIT_OPEN_ERROR")
+)
+
+// CircuitState type
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig struct
+type CircuitBreakerConfig struct {
+	// Window is the period failures are counted in while closed
+	Window time.Duration
+	// MinRequests in Window before FailureRate is evaluated
+	MinRequests int
+	// FailureRate in [0, 1] which opens the circuit
+	FailureRate float64
+	// Cooldown is how long the circuit stays open before trying half-open
+	Cooldown time.Duration
+	// HalfOpenMaxRequests is the number of trial requests while half-open
+	HalfOpenMaxRequests int
+}
+
+// DefaultCircuitBreakerConfig function
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Window:              30 * time.Second,
+		MinRequests:         10,
+		FailureRate:         0.5,
+		Cooldown:            10 * time.Second,
+		HalfOpenMaxRequests: 1,
+	}
+}
+
+// CircuitStateChangeFunc is called after a breaker changes state
+type CircuitStateChangeFunc func(name string, from, to CircuitState)
+
+// CircuitBreaker struct
+type CircuitBreaker struct {
+	name     string
+	config   CircuitBreakerConfig
+	onChange []CircuitStateChangeFunc
+
+	mu          sync.Mutex
+	state       CircuitState
+	generation  uint64
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	inFlight    int
+}
+
+// NewCircuitBreaker function create new CircuitBreaker, zero config values take DefaultCircuitBreakerConfig
+func NewCircuitBreaker(name string, config CircuitBreakerConfig, onChange ...CircuitStateChangeFunc) *CircuitBreaker {
+	defaults := DefaultCircuitBreakerConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaults.MinRequests
+	}
+	if config.FailureRate <= 0 {
+		config.FailureRate = defaults.FailureRate
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaults.Cooldown
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = defaults.HalfOpenMaxRequests
+	}
+	return &CircuitBreaker{
+		name:        name,
+		config:      config,
+		onChange:    onChange,
+		windowStart: time.Now(),
+	}
+}
+
+// Name function
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State function return current state
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	from, to := cb.refresh(time.Now())
+	state := cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+	return state
+}
+
+// Allow function check whether a request may pass, caller must call done with the request result
+func (cb *CircuitBreaker) Allow() (func(success bool), error) {
+	cb.mu.Lock()
+	from, to := cb.refresh(time.Now())
+
+	var err error
+	switch cb.state {
+	case CircuitOpen:
+		err = CircuitOpenError
+	case CircuitHalfOpen:
+		if cb.inFlight >= cb.config.HalfOpenMaxRequests {
+			err = CircuitOpenError
+		}
+	}
+	if err == nil {
+		cb.inFlight++
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
+	cb.notify(from, to)
+
+	if err != nil {
+		return nil, err
+	}
+	return func(success bool) {
+		cb.done(generation, success)
+	}, nil
+}
+
+// Execute function run fn when circuit allows, fn error counts as failure
+func (cb *CircuitBreaker) Execute(fn func() error) error {
+	done, err := cb.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+func (cb *CircuitBreaker) done(generation uint64, success bool) {
+	cb.mu.Lock()
+	now := time.Now()
+	from, to := cb.refresh(now)
+	if generation != cb.generation {
+		// result of a request started in a previous state
+		cb.mu.Unlock()
+		cb.notify(from, to)
+		return
+	}
+	cb.inFlight--
+	cb.requests++
+	if !success {
+		cb.failures++
+	}
+
+	switch cb.state {
+	case CircuitClosed:
+		if cb.requests >= cb.config.MinRequests && float64(cb.failures)/float64(cb.requests) >= cb.config.FailureRate {
+			from, to = cb.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if !success {
+			from, to = cb.setState(CircuitOpen, now)
+		} else if cb.requests >= cb.config.HalfOpenMaxRequests {
+			from, to = cb.setState(CircuitClosed, now)
+		}
+	}
+	cb.mu.Unlock()
+	cb.notify(from, to)
+}
+
+// refresh function move open to half-open after cooldown and reset closed window, must hold mu
+func (cb *CircuitBreaker) refresh(now time.Time) (CircuitState, CircuitState) {
+	switch cb.state {
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) >= cb.config.Cooldown {
+			return cb.setState(CircuitHalfOpen, now)
+		}
+	case CircuitClosed:
+		if now.Sub(cb.windowStart) >= cb.config.Window {
+			cb.windowStart = now
+			cb.requests = 0
+			cb.failures = 0
+		}
+	}
+	return cb.state, cb.state
+}
+
+// setState function must hold mu
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) (CircuitState, CircuitState) {
+	from := cb.state
+	cb.state = state
+	cb.generation++
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+	cb.inFlight = 0
+	if state == CircuitOpen {
+		cb.openedAt = now
+	}
+	return from, state
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from == to {
+		return
+	}
+	for _, fn := range cb.onChange {
+		fn(cb.name, from, to)
+	}
+}
+
+// CircuitBreakerRegistry struct holds one CircuitBreaker per downstream service, see CircuitBreakerKey
+type CircuitBreakerRegistry struct {
+	config   CircuitBreakerConfig
+	mu       sync.RWMutex
+	breakers map[string]*CircuitBreaker
+	onChange []CircuitStateChangeFunc
+}
+
+// CircuitBreakerRegistry global instance
+var (
+	GlobalCircuitBreakers = NewCircuitBreakerRegistry(DefaultCircuitBreakerConfig())
+)
+
+// NewCircuitBreakerRegistry function create new CircuitBreakerRegistry
+func NewCircuitBreakerRegistry(config CircuitBreakerConfig) *CircuitBreakerRegistry {
+	return &CircuitBreakerRegistry{
+		config:   config,
+		breakers: map[string]*CircuitBreaker{},
+	}
+}
+
+// OnStateChange function register callback for every breaker of registry
+func (r *CircuitBreakerRegistry) OnStateChange(fn CircuitStateChangeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, fn)
+}
+
+// Get function return breaker of address, create it when missing
+func (r *CircuitBreakerRegistry) Get(address string) *CircuitBreaker {
+	r.mu.RLock()
+	cb, ok := r.breakers[address]
+	r.mu.RUnlock()
+	if ok {
+		return cb
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cb, ok = r.breakers[address]; ok {
+		return cb
+	}
+	cb = NewCircuitBreaker(address, r.config, func(name string, from, to CircuitState) {
+		r.mu.RLock()
+		callbacks := r.onChange
+		r.mu.RUnlock()
+		for _, fn := range callbacks {
+			fn(name, from, to)
+		}
+	})
+	r.breakers[address] = cb
+	return cb
+}
+
+// States function return state of every known address, for health checks
+func (r *CircuitBreakerRegistry) States() map[string]CircuitState {
+	r.mu.RLock()
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, cb := range r.breakers {
+		breakers = append(breakers, cb)
+	}
+	r.mu.RUnlock()
+
+	states := make(map[string]CircuitState, len(breakers))
+	for _, cb := range breakers {
+		states[cb.Name()] = cb.State()
+	}
+	return states
+}
+
+// CircuitBreakerKey function return breaker key of a service address or gRPC target: the service name,
+// or "host:grpcPort" for plain addresses, so HTTP and gRPC calls to one service share a breaker
+func CircuitBreakerKey(serviceAddrOrTarget string) string {
+	if i := strings.Index(serviceAddrOrTarget, "://"); i >= 0 {
+		// scheme://authority/endpoint
+		endpoint := serviceAddrOrTarget[i+3:]
+		if j := strings.Index(endpoint, "/"); j >= 0 {
+			endpoint = endpoint[j+1:]
+		}
+		return endpoint
+	}
+	return serviceAddrOrTarget
+}
+
+type circuitBreakerKeyContextKey struct{}
+
+// WithCircuitBreakerKey function return ctx selecting breaker of key for HttpClient requests
+func WithCircuitBreakerKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, circuitBreakerKeyContextKey{}, key)
+}
+
+// withServiceCircuitBreakerKey function set breaker key of urlOrServiceAddr on ctx, full urls keep the url host
+func withServiceCircuitBreakerKey(ctx context.Context, urlOrServiceAddr string) context.Context {
+	if strings.HasPrefix(urlOrServiceAddr, "http") {
+		return ctx
+	}
+	return WithCircuitBreakerKey(ctx, CircuitBreakerKey(urlOrServiceAddr))
+}
+
+// circuitBreakerKeyOf function return breaker key of ctx or req context, url host otherwise
+func circuitBreakerKeyOf(ctx context.Context, req *http.Request) string {
+	for _, c := range []context.Context{ctx, req.Context()} {
+		if key, ok := c.Value(circuitBreakerKeyContextKey{}).(string); ok && key != "" {
+			return key
+		}
+	}
+	return req.URL.Host
+}
+
+// isCircuitFailureCode function return true for gRPC codes which mean the remote is unhealthy
+func isCircuitFailureCode(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+func circuitSuccess(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return true
+	}
+	return !isCircuitFailureCode(status.Code(err))
+}
+
+// CircuitBreakerClientUnary interceptor function, breaker is selected by CircuitBreakerKey of connection target
+func CircuitBreakerClientUnary(registry *CircuitBreakerRegistry) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := registry.Get(CircuitBreakerKey(cc.Target())).Allow()
+		if err != nil {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(circuitSuccess(ctx, err))
+		return err
+	}
+}
+
+// CircuitBreakerClientStream interceptor function, only stream creation is counted
+func CircuitBreakerClientStream(registry *CircuitBreakerRegistry) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := registry.Get(CircuitBreakerKey(cc.Target())).Allow()
+		if err != nil {
+			return nil, err
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		done(circuitSuccess(ctx, err))
+		return stream, err
+	}
+}
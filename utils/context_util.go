@@ -7,6 +7,9 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+// MakeContext function copy every http header to incoming metadata.
+//
+// Deprecated: it forwards hop-by-hop and unknown headers, use MakePropagatedContext
 func MakeContext(r *http.Request) context.Context {
 	// Extract headers from the HTTP request
 	md := metadata.New(nil)
@@ -19,6 +22,22 @@ func MakeContext(r *http.Request) context.Context {
 		}
 	}
 
+	return makeHttpContext(r, md)
+}
+
+// MakePropagatedContext function same as MakeContext but copy only headers allowed by GlobalPropagationPolicy
+func MakePropagatedContext(r *http.Request) context.Context {
+	// Extract headers from the HTTP request
+	md := metadata.New(nil)
+	md.Append("pattern", r.URL.String())
+	md.Append("x-forwarded-host", r.Host)
+
+	GlobalPropagationPolicy.HeaderToMetadata(r.Header, md)
+
+	return makeHttpContext(r, md)
+}
+
+func makeHttpContext(r *http.Request, md metadata.MD) context.Context {
 	// Create a gRPC context with the metadata
 	ctx := metadata.NewIncomingContext(context.Background(), md)
 
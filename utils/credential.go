@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ApiKeyHeader carries api keys
+	ApiKeyHeader = "x-api-key"
+
+	serviceTokenType = "service"
+)
+
+var (
+	//InvalidApiKeyError error
+	InvalidApiKeyError = status.Error(codes.Unauthenticated, "SYS.MSG.INVALID_API_KEY_ERROR")
+	//UnknownServiceError error
+	UnknownServiceError = status.Error(codes.PermissionDenied, "SYS.MSG.UNKNOWN_SERVICE_ERROR")
+)
+
+// CredentialResolver interface resolve a non user credential of incoming request into a Principal,
+// ok is false when the request does not carry this kind of credential
+type CredentialResolver interface {
+	Resolve(ctx context.Context) (principal *Principal, ok bool, err error)
+}
+
+// ApiKey struct is a stored api key, only the hash of the key is kept
+type ApiKey struct {
+	Id   string
+	Name string
+	// Hash is HashApiKey of the key
+	Hash string
+	// Scopes become the principal permissions, checked by PolicyRegistry
+	Scopes    []string
+	PartnerId int64
+	// ExpiresAt, zero for no expiry
+	ExpiresAt time.Time
+	Disabled  bool
+}
+
+// ApiKeyStore interface find api keys by hash
+type ApiKeyStore interface {
+	ApiKeyByHash(ctx context.Context, hash string) (ApiKey, bool, error)
+}
+
+// HashApiKey function return hash stored for key
+func HashApiKey(key string) string {
+	return Sha256(key)
+}
+
+// GenerateApiKey function return new random key with prefix, e.g. "sk_...", and its hash to store
+func GenerateApiKey(prefix string) (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := prefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashApiKey(key), nil
+}
+
+// MemoryApiKeyStore struct is an in-memory ApiKeyStore
+type MemoryApiKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]ApiKey
+}
+
+// NewMemoryApiKeyStore function create new MemoryApiKeyStore
+func NewMemoryApiKeyStore(keys ...ApiKey) *MemoryApiKeyStore {
+	store := &MemoryApiKeyStore{
+		keys: map[string]ApiKey{},
+	}
+	for _, key := range keys {
+		store.Add(key)
+	}
+	return store
+}
+
+// Add function add or replace key
+func (s *MemoryApiKeyStore) Add(key ApiKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.Hash] = key
+}
+
+// Remove function remove key of id
+func (s *MemoryApiKeyStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, key := range s.keys {
+		if key.Id == id {
+			delete(s.keys, hash)
+		}
+	}
+}
+
+func (s *MemoryApiKeyStore) ApiKeyByHash(ctx context.Context, hash string) (ApiKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[hash]
+	return key, ok, nil
+}
+
+// ApiKeyCredentialResolver struct resolve api key of ApiKeyHeader metadata
+type ApiKeyCredentialResolver struct {
+	Store ApiKeyStore
+}
+
+// NewApiKeyCredentialResolver function create new ApiKeyCredentialResolver
+func NewApiKeyCredentialResolver(store ApiKeyStore) *ApiKeyCredentialResolver {
+	return &ApiKeyCredentialResolver{Store: store}
+}
+
+func (r *ApiKeyCredentialResolver) Resolve(ctx context.Context) (*Principal, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false, nil
+	}
+	values := md.Get(ApiKeyHeader)
+	if len(values) == 0 || values[0] == "" {
+		return nil, false, nil
+	}
+
+	key, found, err := r.Store.ApiKeyByHash(ctx, HashApiKey(values[0]))
+	if err != nil {
+		return nil, true, err
+	}
+	if !found || key.Disabled || (!key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt)) {
+		return nil, true, InvalidApiKeyError
+	}
+	return &Principal{
+		AccountClaims: AccountClaims{
+			PartnerId:   key.PartnerId,
+			DiffHour:    DiffHourNil,
+			Permissions: key.Scopes,
+		},
+		Kind:           PrincipalApiKey,
+		ServiceName:    key.Name,
+		ApiKeyId:       key.Id,
+		SessionContext: SessionContext{DiffHour: DiffHourNil},
+	}, true, nil
+}
+
+// GenerateServiceToken function return token of a service, "sub" is the service name and scopes become permissions
+func (manager *JwtManager) GenerateServiceToken(service string, scopes []string, expDuration time.Duration) (string, error) {
+	if service == "" {
+		return "", errors.New("service name is required")
+	}
+	claims := manager.registeredClaims()
+	claims.Subject = service
+	claims.ID = NewTokenId()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expDuration))
+
+	mapClaims := registeredMapClaims(claims)
+	mapClaims["typ"] = serviceTokenType
+	if len(scopes) > 0 {
+		mapClaims["scopes"] = scopes
+	}
+	return manager.signToken(mapClaims)
+}
+
+// ServiceTokenCredentialResolver struct resolve bearer tokens made by GenerateServiceToken
+type ServiceTokenCredentialResolver struct {
+	JwtManager *JwtManager
+	// Services allowed to call, empty allows every service
+	Services map[string]bool
+}
+
+// NewServiceTokenCredentialResolver function create new ServiceTokenCredentialResolver
+func NewServiceTokenCredentialResolver(jwtManager *JwtManager, services ...string) *ServiceTokenCredentialResolver {
+	r := &ServiceTokenCredentialResolver{
+		JwtManager: jwtManager,
+		Services:   map[string]bool{},
+	}
+	for _, service := range services {
+		r.Services[service] = true
+	}
+	return r
+}
+
+func (r *ServiceTokenCredentialResolver) Resolve(ctx context.Context) (*Principal, bool, error) {
+	accessToken, _, err := GetLoginAccessToken(ctx)
+	if err != nil {
+		return nil, false, nil
+	}
+	// cheap check before verifying, user tokens are left to the user path
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, unverified); err != nil || unverified["typ"] != serviceTokenType {
+		return nil, false, nil
+	}
+
+	claims, err := r.JwtManager.verify(accessToken, serviceTokenType)
+	if err != nil {
+		return nil, true, err
+	}
+	service, _ := claims.GetSubject()
+	if service == "" || (len(r.Services) > 0 && !r.Services[service]) {
+		return nil, true, UnknownServiceError
+	}
+	decoder := claimsDecoder{claims: *claims}
+	scopes := decoder.strings("scopes")
+	if err := decoder.err(); err != nil {
+		return nil, true, err
+	}
+	accountClaims := AccountClaims{DiffHour: DiffHourNil, Permissions: scopes}
+	if err := decodeClaimsJSON(*claims, &accountClaims.RegisteredClaims); err != nil {
+		return nil, true, err
+	}
+	return &Principal{
+		AccountClaims:  accountClaims,
+		Kind:           PrincipalService,
+		ServiceName:    service,
+		SessionContext: SessionContext{DiffHour: DiffHourNil},
+		Token:          accessToken,
+		Claims:         *claims,
+	}, true, nil
+}
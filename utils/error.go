@@ -99,14 +99,14 @@ func DuplicatedError(field string) error {
 func CatchError() {
 	if err := recover(); err != nil {
 		e := stackerror.New("=======CatchError=======")
-		fmt.Println("CatchError has error: ", e.Error())
+		Logger().Error("CatchError recovered", "recovered", err, "stack", e.Error())
 	}
 }
 
 func TxCatchError(tx *sql.Tx) {
 	if err := recover(); err != nil {
 		e := stackerror.New("=======CatchError=======")
-		fmt.Println("CatchError has error: ", e.Error())
+		Logger().Error("TxCatchError recovered, rollback", "recovered", err, "stack", e.Error())
 		tx.Rollback()
 	} else {
 		tx.Commit()
@@ -3,10 +3,8 @@ package utils
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"strings"
 )
@@ -59,10 +57,10 @@ func IsFileExisted(filePath string) (bool, error) {
 	_, err := os.Stat(filePath)
 
 	if errors.Is(err, os.ErrNotExist) {
-		fmt.Println("file does not exist")
+		Logger().Debug("file does not exist", "path", filePath)
 		return false, nil
 	} else if err == nil {
-		fmt.Println("file exists")
+		Logger().Debug("file exists", "path", filePath)
 		return true, nil
 	}
 	return false, err
@@ -80,7 +78,7 @@ func CreateFile(filePath string) error {
 	file, err := os.Create(filePath)
 	defer file.Close()
 	if err != nil {
-		log.Fatal(err)
+		Logger().Error("CreateFile error", "path", filePath, "error", err)
 		return err
 	}
 	return nil
@@ -91,7 +89,7 @@ func WriteFile(filePath string, content string) error {
 	err := ioutil.WriteFile(filePath, []byte(content), 0644)
 
 	if err != nil {
-		log.Fatal(err)
+		Logger().Error("WriteFile error", "path", filePath, "error", err)
 		return err
 	}
 
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"log"
 	"os"
 	"strings"
 )
@@ -18,7 +17,7 @@ func WriteHl7OrderRis(path string, indicationItemId, patientCode, patientName st
 	if !existed {
 		err := os.MkdirAll(path, os.ModePerm)
 		if err != nil {
-			log.Println(err)
+			Logger().Error("create hl7 directory error", "path", path, "error", err)
 			return "", err
 		}
 	}
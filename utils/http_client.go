@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 2
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 3 * time.Second
+	// DefaultHttpClientTimeout bounds a whole request of DefaultHttpClient (the JSON helpers),
+	// file helpers are bounded by their context only
+	DefaultHttpClientTimeout = 60 * time.Second
+)
+
+// RetryPolicy struct holds retry config for outbound requests
+type RetryPolicy struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Methods allowed to retry, default is idempotent methods only
+	Methods map[string]bool
+}
+
+// DefaultRetryPolicy function return retry policy for idempotent methods
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: DefaultMaxRetries,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Methods: map[string]bool{
+			http.MethodGet:     true,
+			http.MethodHead:    true,
+			http.MethodOptions: true,
+			http.MethodPut:     true,
+			http.MethodDelete:  true,
+			http.MethodTrace:   true,
+		},
+	}
+}
+
+// NoRetryPolicy function return retry policy which never retries
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{}
+}
+
+// HttpClient struct is a reusable, context-aware http client with retry
+type HttpClient struct {
+	Client *http.Client
+	Retry  RetryPolicy
+	// Breakers selects a circuit breaker by WithCircuitBreakerKey of the request context, or request host; nil disables it
+	Breakers *CircuitBreakerRegistry
+}
+
+var (
+	// DefaultHttpClient is shared by the REST helpers
+	DefaultHttpClient = &HttpClient{
+		Client: &http.Client{
+			Transport: NewMiddlewareTransport(http.DefaultTransport.(*http.Transport).Clone()),
+			Timeout:   DefaultHttpClientTimeout,
+		},
+		Retry:    DefaultRetryPolicy(),
+		Breakers: GlobalCircuitBreakers,
+	}
+)
+
+// streamHttpClient function return DefaultHttpClient without Timeout, for downloads and uploads
+// which may outlive it. The transport is shared
+func streamHttpClient() *HttpClient {
+	client := *DefaultHttpClient
+	httpClient := *client.Client
+	httpClient.Timeout = 0
+	client.Client = &httpClient
+	return &client
+}
+
+// NewHttpClient function create new HttpClient
+func NewHttpClient(client *http.Client, retry RetryPolicy) *HttpClient {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &HttpClient{
+		Client: client,
+		Retry:  retry,
+	}
+}
+
+// Do function send request bound to ctx, retrying on connection errors, 429 and 5xx
+func (c *HttpClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	// retries of a non idempotent request share one idempotency key
+	if c.Retry.MaxRetries > 0 && c.Retry.Methods[req.Method] && isNonIdempotentMethod(req.Method) {
+		if _, ok := IdempotencyKeyFromContext(ctx); !ok {
+			ctx = WithIdempotencyKey(ctx, NewIdempotencyKey())
+		}
+	}
+	breakerKey := circuitBreakerKeyOf(ctx, req)
+	req = req.WithContext(ctx)
+
+	if c.Breakers == nil {
+		return c.do(ctx, req)
+	}
+	done, err := c.Breakers.Get(breakerKey).Allow()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, req)
+	done(ctx.Err() != nil || !shouldRetry(ctx, res, err))
+	return res, err
+}
+
+func (c *HttpClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	canRetry := c.Retry.MaxRetries > 0 && c.Retry.Methods[req.Method] && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		res, err := c.Client.Do(req)
+		if !canRetry || attempt >= c.Retry.MaxRetries || !shouldRetry(ctx, res, err) {
+			return res, err
+		}
+
+		wait := c.Retry.backoff(attempt)
+		if res != nil {
+			// Retry-After is capped by MaxBackoff, a server can not block the caller longer
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+				wait = min(retryAfter, c.Retry.maxBackoff())
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// no time left for another attempt, return the last result
+			return res, err
+		}
+		if res != nil {
+			// drain body to reuse connection
+			io.Copy(io.Discard, io.LimitReader(res.Body, BUFFER_SIZE))
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// minBackoff function return MinBackoff, DefaultMinBackoff when not set
+func (p RetryPolicy) minBackoff() time.Duration {
+	if p.MinBackoff <= 0 {
+		return DefaultMinBackoff
+	}
+	return p.MinBackoff
+}
+
+// maxBackoff function return MaxBackoff, DefaultMaxBackoff when not set, never below minBackoff
+func (p RetryPolicy) maxBackoff() time.Duration {
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	return max(maxBackoff, p.minBackoff())
+}
+
+// backoff function return random wait between MinBackoff and MinBackoff << attempt, capped by MaxBackoff
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	minBackoff := p.minBackoff()
+	maxBackoff := p.maxBackoff()
+	wait := minBackoff << uint(attempt)
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return minBackoff + time.Duration(rand.Int63n(int64(wait-minBackoff)+1))
+}
+
+func shouldRetry(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError && res.StatusCode != http.StatusNotImplemented
+}
+
+// parseRetryAfter function parse Retry-After header, in seconds or http date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
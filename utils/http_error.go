@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPError struct holds a non success response of a downstream service
+type HTTPError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// NewHTTPError function create HTTPError from response and its already read body
+func NewHTTPError(res *http.Response, body []byte) *HTTPError {
+	e := &HTTPError{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+	if res.Request != nil {
+		e.Method = res.Request.Method
+		if res.Request.URL != nil {
+			e.Url = res.Request.URL.String()
+		}
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("[%v]%v -> %v: %v", e.Method, e.Url, e.StatusCode, e.Message())
+}
+
+// Is function keep errors.Is(err, CallRESTAPIError) working for old callers
+func (e *HTTPError) Is(target error) bool {
+	return target == CallRESTAPIError
+}
+
+// Message function return message of response body, read from json "message" field when possible
+func (e *HTTPError) Message() string {
+	var body map[string]interface{}
+	if err := json.Unmarshal(e.Body, &body); err == nil {
+		if message, ok := body["message"].(string); ok && message != "" {
+			return message
+		}
+	}
+	message := strings.TrimSpace(string(e.Body))
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	return message
+}
+
+// GRPCStatus function let status.FromError convert HTTPError to gRPC status
+func (e *HTTPError) GRPCStatus() *status.Status {
+	return status.New(HTTPStatusToGrpcCode(e.StatusCode), e.Message())
+}
+
+// AsHTTPError function return HTTPError in err chain
+func AsHTTPError(err error) (*HTTPError, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr, true
+	}
+	return nil, false
+}
+
+// HTTPStatusToGrpcCode function map http status code to gRPC code
+func HTTPStatusToGrpcCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return codes.OK
+	case statusCode >= 400 && statusCode < 500:
+		return codes.FailedPrecondition
+	case statusCode >= 500:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+type idempotencyKeyContextKey struct{}
+
+// HttpMiddleware wraps an outbound http.RoundTripper
+type HttpMiddleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc type adapts a function to http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var (
+	outboundMiddlewaresMu sync.RWMutex
+	outboundMiddlewares   []HttpMiddleware
+)
+
+// UseHttpMiddleware function register middlewares shared by RequestUtil, SendRawFromRequest and SendMultiPartForm.
+// Middlewares run in registration order, the first one is the outermost
+func UseHttpMiddleware(middlewares ...HttpMiddleware) {
+	outboundMiddlewaresMu.Lock()
+	defer outboundMiddlewaresMu.Unlock()
+	outboundMiddlewares = append(outboundMiddlewares, middlewares...)
+}
+
+// ResetHttpMiddleware function remove all registered middlewares
+func ResetHttpMiddleware() {
+	outboundMiddlewaresMu.Lock()
+	defer outboundMiddlewaresMu.Unlock()
+	outboundMiddlewares = nil
+}
+
+// ChainHttpMiddleware function wrap base with middlewares, the first one is the outermost
+func ChainHttpMiddleware(base http.RoundTripper, middlewares ...HttpMiddleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		base = middlewares[i](base)
+	}
+	return base
+}
+
+// middlewareTransport struct apply the registered middlewares on every request,
+// so middlewares registered after a client was built still apply
+type middlewareTransport struct {
+	base http.RoundTripper
+}
+
+// NewMiddlewareTransport function wrap base with the registered middlewares
+func NewMiddlewareTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := base.(*middlewareTransport); ok {
+		return base
+	}
+	return &middlewareTransport{base: base}
+}
+
+func (t *middlewareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	outboundMiddlewaresMu.RLock()
+	middlewares := outboundMiddlewares
+	outboundMiddlewaresMu.RUnlock()
+	return ChainHttpMiddleware(t.base, middlewares...).RoundTrip(req)
+}
+
+// LoggingHttpMiddleware function log request and response, values of redactHeaders are hidden.
+// Authorization, Cookie and Set-Cookie are always redacted
+func LoggingHttpMiddleware(redactHeaders ...string) HttpMiddleware {
+	redact := map[string]bool{
+		"Authorization": true,
+		"Cookie":        true,
+		"Set-Cookie":    true,
+	}
+	for _, key := range redactHeaders {
+		redact[http.CanonicalHeaderKey(key)] = true
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			ctx := req.Context()
+			Logger().InfoContext(ctx, "HTTP request", "httpMethod", req.Method, "url", req.URL.String(), "headers", redactHeader(req.Header, redact))
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				Logger().ErrorContext(ctx, "HTTP response error", "httpMethod", req.Method, "url", req.URL.String(), "error", err, "duration", time.Since(start))
+				return res, err
+			}
+			Logger().InfoContext(ctx, "HTTP response", "httpMethod", req.Method, "url", req.URL.String(), "status", res.StatusCode,
+				"duration", time.Since(start), "headers", redactHeader(res.Header, redact))
+			return res, err
+		})
+	}
+}
+
+func redactHeader(header http.Header, redact map[string]bool) http.Header {
+	result := make(http.Header, len(header))
+	for key, values := range header {
+		if redact[http.CanonicalHeaderKey(key)] {
+			result[key] = []string{"[REDACTED]"}
+		} else {
+			result[key] = values
+		}
+	}
+	return result
+}
+
+// TimingHttpMiddleware function call observe with the duration of every request, for metrics
+func TimingHttpMiddleware(observe func(req *http.Request, res *http.Response, err error, duration time.Duration)) HttpMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := next.RoundTrip(req)
+			observe(req, res, err, time.Since(start))
+			return res, err
+		})
+	}
+}
+
+// HeaderPropagationHttpMiddleware function copy keys from gRPC incoming metadata of request context
+// to request headers, headers already set are kept
+func HeaderPropagationHttpMiddleware(keys ...string) HttpMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			md, ok := metadata.FromIncomingContext(req.Context())
+			if !ok {
+				return next.RoundTrip(req)
+			}
+			cloned := false
+			for _, key := range keys {
+				values := md.Get(key)
+				if len(values) == 0 || req.Header.Get(key) != "" {
+					continue
+				}
+				if !cloned {
+					req = req.Clone(req.Context())
+					cloned = true
+				}
+				for _, value := range values {
+					req.Header.Add(key, value)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// IdempotencyKeyHttpMiddleware function set Idempotency-Key header on non idempotent requests,
+// the key of WithIdempotencyKey is used when present so retries share the same key
+func IdempotencyKeyHttpMiddleware() HttpMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(IdempotencyKeyHeader) != "" || !isNonIdempotentMethod(req.Method) {
+				return next.RoundTrip(req)
+			}
+			key, ok := IdempotencyKeyFromContext(req.Context())
+			if !ok {
+				key = NewIdempotencyKey()
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set(IdempotencyKeyHeader, key)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func isNonIdempotentMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return method == http.MethodPost || method == http.MethodPatch
+}
+
+// NewIdempotencyKey function return random key
+func NewIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithIdempotencyKey function attach idempotency key to ctx
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKeyFromContext function return idempotency key of ctx
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok && key != ""
+}
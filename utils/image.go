@@ -243,7 +243,7 @@ func CropImageByMargin(data string, top, left, right, bottom int) (string, error
 
 	image, _, err := image.DecodeConfig(bytes.NewReader(dataBytes))
 	if err != nil {
-		fmt.Println(err)
+		Logger().Warn("decode image config error", "error", err)
 	}
 	width, height := image.Width, image.Height
 
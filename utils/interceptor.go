@@ -2,23 +2,31 @@ package utils
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"sync"
 
-	"github.com/avct/uasurfer"
 	"google.golang.org/grpc/metadata"
 
 	"google.golang.org/grpc"
-	// "suntech.com.vn/skylib/skylog.git/skylog"
 )
 
 var (
 	GlobalAuthInterceptor *AuthInterceptor
+
+	// LockScreensMutex guards the deprecated lockScreens maps of Unary and Stream,
+	// code updating such a map while the server runs must hold it
+	LockScreensMutex sync.RWMutex
 )
 
 // AuthInterceptor struct
 type AuthInterceptor struct {
 	jwtManager *JwtManager
+	revocation RevocationChecker
+	policies   *PolicyRegistry
+
+	sessionState          SessionStateProvider
+	passwordChangeMethods map[string]bool
+	credentials           []CredentialResolver
+	tokenSource           TokenSource
 }
 
 // NewAuthInterceptor function: create new AuthInterceptor
@@ -29,21 +37,57 @@ func NewAuthInterceptor(jwtManager *JwtManager) *AuthInterceptor {
 	}
 }
 
+// WithRevocationChecker function set checker consulted by authorize with the request context
+func (interceptor *AuthInterceptor) WithRevocationChecker(checker RevocationChecker) *AuthInterceptor {
+	interceptor.revocation = checker
+	return interceptor
+}
+
+// WithPolicies function set per-method role / permission policies checked by authorize
+func (interceptor *AuthInterceptor) WithPolicies(policies *PolicyRegistry) *AuthInterceptor {
+	interceptor.policies = policies
+	return interceptor
+}
+
+// WithSessionState function set provider of locked / suspended / force-password-change state checked by authorize,
+// passwordChangeMethods stay allowed while a password change is forced
+func (interceptor *AuthInterceptor) WithSessionState(provider SessionStateProvider, passwordChangeMethods ...string) *AuthInterceptor {
+	interceptor.sessionState = provider
+	interceptor.passwordChangeMethods = map[string]bool{}
+	for _, method := range passwordChangeMethods {
+		interceptor.passwordChangeMethods[method] = true
+	}
+	return interceptor
+}
+
+// WithCredentialResolvers function add resolvers of api keys, service tokens... tried in order before the user token
+func (interceptor *AuthInterceptor) WithCredentialResolvers(resolvers ...CredentialResolver) *AuthInterceptor {
+	interceptor.credentials = append(interceptor.credentials, resolvers...)
+	return interceptor
+}
+
+// WithTokenSource function set source of the token attached by client interceptors,
+// default forwards the incoming user token only
+func (interceptor *AuthInterceptor) WithTokenSource(source TokenSource) *AuthInterceptor {
+	interceptor.tokenSource = source
+	return interceptor
+}
+
 // Init function
 func Init(jwtManager *JwtManager) {
 	GlobalAuthInterceptor = NewAuthInterceptor(jwtManager)
 }
 
-// Unary interceptor function
+// Unary interceptor function, lockScreens is deprecated: pass nil and use WithSessionState,
+// or hold LockScreensMutex while updating it
 func (interceptor *AuthInterceptor) Unary(publicMethods map[string]bool, lockScreens map[int64]bool) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// skylog.Info("Unary Func publicMethods", publicMethods)
-		if err := interceptor.authorize(ctx, info.FullMethod, publicMethods, lockScreens); err != nil {
-			// skylog.Error(err)
+		ctx, err := interceptor.authorize(ctx, info.FullMethod, publicMethods, lockScreens)
+		if err != nil {
+			Logger().WarnContext(ctx, "authorize error", "error", err)
 			return nil, err
 		}
-		// skylog.Info("Unary Func publicMethods authorize done")
-		PrintRequest(info.FullMethod, req)
+		PrintRequestContext(ctx, info.FullMethod, req)
 		return handler(ctx, req)
 	}
 }
@@ -51,55 +95,78 @@ func (interceptor *AuthInterceptor) Unary(publicMethods map[string]bool, lockScr
 // ClientUnary interceptor function
 func (interceptor *AuthInterceptor) ClientUnary() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
+		ctx, err := interceptor.attachToken(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
 
-// ClientUnaryWithPackage interceptor function
+// ClientUnaryWithPackage interceptor function, replace every occurrence of test in method with replaceWith
+//
+// Deprecated: chain RouteClientUnary with a RouteTable before ClientUnary
 func (interceptor *AuthInterceptor) ClientUnaryWithPackage(test, replaceWith string) grpc.UnaryClientInterceptor {
+	rewrite := legacyMethodRewrite(test, replaceWith)
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		method = strings.ReplaceAll(method, test, replaceWith)
-		return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
+		method = rewrite(method)
+		ctx, err := interceptor.attachToken(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
 
-func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil
+// attachToken function return ctx with token of tokenSource and propagated incoming metadata,
+// metadata already on the outgoing context is kept
+func (interceptor *AuthInterceptor) attachToken(ctx context.Context) (context.Context, error) {
+	source := interceptor.tokenSource
+	if source == nil {
+		source = IncomingTokenSource()
 	}
-	auths := md["authorization"]
-	if len(auths) == 0 {
-		return nil
-	}
-	accessToken := strings.Replace(auths[0], "Bearer ", "", 1)
-	params := []string{
-		"authorization",
-		accessToken,
-	}
-	if grpcGatewayOrigin, ok := md["grpcgateway-origin"]; ok {
-		params = append(params, "grpcgateway-origin", grpcGatewayOrigin[0])
+
+	var params []string
+	outgoing, _ := metadata.FromOutgoingContext(ctx)
+	if len(outgoing.Get("authorization")) == 0 {
+		accessToken, err := source.Token(ctx)
+		if err != nil {
+			return ctx, err
+		}
+		if accessToken != "" {
+			params = append(params, "authorization", accessToken)
+		}
 	}
-	if origin, ok := md["origin"]; ok {
-		params = append(params, "origin", origin[0])
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		propagated := outboundPropagationPolicy().Without("authorization").MetadataToMetadata(md, nil)
+		for key, values := range propagated {
+			if len(outgoing.Get(key)) > 0 {
+				continue
+			}
+			for _, value := range values {
+				params = append(params, key, value)
+			}
+		}
 	}
-	if xForwardedHost, ok := md["x-forwarded-host"]; ok {
-		params = append(params, "x-forwarded-host", xForwardedHost[0])
+	if len(params) == 0 {
+		return ctx, nil
 	}
-
-	return metadata.AppendToOutgoingContext(ctx, params...)
+	return metadata.AppendToOutgoingContext(ctx, params...), nil
 }
 
-// Stream interceptor function
+// Stream interceptor function, lockScreens is deprecated: pass nil and use WithSessionState,
+// or hold LockScreensMutex while updating it
 func (interceptor *AuthInterceptor) Stream(publicMethods map[string]bool, lockScreens map[int64]bool) grpc.StreamServerInterceptor {
 	return func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		// skylog.Info("Stream function publicMethods 1", publicMethods)
-		if err := interceptor.authorize(stream.Context(), info.FullMethod, publicMethods, lockScreens); err != nil {
-			// skylog.Error(err)
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod, publicMethods, lockScreens)
+		if err != nil {
+			Logger().WarnContext(ctx, "authorize error", "error", err)
 			return err
 		}
-		// skylog.Info("Stream function publicMethods 2")
-		PrintRequest(info.FullMethod, "")
+		if ctx != stream.Context() {
+			stream = &principalServerStream{ServerStream: stream, ctx: ctx}
+		}
+		PrintRequestContext(ctx, info.FullMethod, "")
 		return handler(server, stream)
 	}
 }
@@ -107,39 +174,105 @@ func (interceptor *AuthInterceptor) Stream(publicMethods map[string]bool, lockSc
 // ClientStream interceptor function
 func (interceptor *AuthInterceptor) ClientStream() grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
+		ctx, err := interceptor.attachToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
 	}
 }
 
-// ClientStream interceptor function
+// ClientStreamWithPackage interceptor function, replace every occurrence of test in method with replaceWith
+//
+// Deprecated: chain RouteClientStream with a RouteTable before ClientStream
 func (interceptor *AuthInterceptor) ClientStreamWithPackage(test, replaceWith string) grpc.StreamClientInterceptor {
+	rewrite := legacyMethodRewrite(test, replaceWith)
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		method = strings.ReplaceAll(method, test, replaceWith)
-		return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
+		method = rewrite(method)
+		ctx, err := interceptor.attachToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string, publicMethods map[string]bool, lockScreens map[int64]bool) error {
-	// skylog.Info(method)
-	// skylog.Info(publicMethods[method])
+// authorize function verify token once and return ctx carrying its Principal
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string, publicMethods map[string]bool, lockScreens map[int64]bool) (context.Context, error) {
 	if publicMethods[method] {
-		return nil
+		return ctx, nil
 	}
-	// skylog.Info("userID 1")
-	userID, err := GetUserID(ctx)
+	principal, err := interceptor.resolveCredential(ctx)
 	if err != nil {
-		// skylog.Error(err)
-		return err
-	}
-	// skylog.Info("userID 2", userID)
-	md, ok := metadata.FromIncomingContext(ctx)
-	if ok {
-		ua := uasurfer.Parse(md["user-agent"][0])
-		fmt.Println(lockScreens[userID])
-		if ua.DeviceType == uasurfer.DeviceComputer && lockScreens[userID] {
-			return NeedLogin
+		return ctx, err
+	}
+	if principal != nil {
+		// service tokens and other resolved tokens are revocable by jti like user tokens
+		if len(principal.Claims) > 0 {
+			if err := checkRevocation(ctx, interceptor.revocation, principal.Claims); err != nil {
+				return ctx, err
+			}
+		}
+		return interceptor.authorizePolicies(ctx, method, principal)
+	}
+	principal, err = interceptor.verify(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	// lock screen only applies to desktop browsers
+	isComputer := isComputerUserAgent(incomingUserAgent(ctx))
+	if isComputer && isLockScreen(lockScreens, principal.UserId) {
+		return ctx, NeedLogin
+	}
+	if interceptor.sessionState != nil {
+		state, err := interceptor.sessionState.SessionState(ctx, principal.UserId, principal.DeviceId)
+		if err != nil {
+			return ctx, err
+		}
+		if err := checkSessionState(state, method, interceptor.passwordChangeMethods, isComputer); err != nil {
+			return ctx, err
+		}
+	}
+	return interceptor.authorizePolicies(ctx, method, principal)
+}
+
+// authorizePolicies function check policies of method and return ctx carrying principal
+func (interceptor *AuthInterceptor) authorizePolicies(ctx context.Context, method string, principal *Principal) (context.Context, error) {
+	if interceptor.policies != nil {
+		if err := interceptor.policies.Authorize(ctx, method, principal); err != nil {
+			return ctx, err
 		}
 	}
+	return ContextWithPrincipal(ctx, principal), nil
+}
+
+// resolveCredential function return principal of the first resolver recognizing the credential, nil for user tokens
+func (interceptor *AuthInterceptor) resolveCredential(ctx context.Context) (*Principal, error) {
+	for _, resolver := range interceptor.credentials {
+		principal, ok, err := resolver.Resolve(ctx)
+		if ok || err != nil {
+			return principal, err
+		}
+	}
+	return nil, nil
+}
 
-	return err
+// verify function return Principal of incoming token
+func (interceptor *AuthInterceptor) verify(ctx context.Context) (*Principal, error) {
+	accessToken, suffix, err := GetLoginAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwtManager := interceptor.jwtManager
+	if jwtManager == nil {
+		jwtManager = JwtManagerInstance
+	}
+	claims, err := jwtManager.Verify(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevocation(ctx, interceptor.revocation, *claims); err != nil {
+		return nil, err
+	}
+	return newPrincipal(accessToken, *claims, sessionContextValue(ctx, suffix))
 }
@@ -0,0 +1,61 @@
+package utils_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vinhduc5984/mylib/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClientUnaryWithPackage(t *testing.T) {
+	tests := []struct {
+		test, replaceWith, method, want string
+	}{
+		{test: "core", replaceWith: "core2", method: "/core.v1.UserService/Get", want: "/core2.v1.UserService/Get"},
+		{test: "core.v1", replaceWith: "core.v2", method: "/core.v1.UserService/Get", want: "/core.v2.UserService/Get"},
+		{test: "UserService", replaceWith: "AccountService", method: "/core.v1.UserService/Get", want: "/core.v1.AccountService/Get"},
+		{test: "billing", replaceWith: "billing2", method: "/core.v1.UserService/Get", want: "/core.v1.UserService/Get"},
+		{test: "", replaceWith: "core2", method: "/core.v1.UserService/Get", want: "/core.v1.UserService/Get"},
+	}
+	for _, tt := range tests {
+		interceptor := utils.NewAuthInterceptor(nil).ClientUnaryWithPackage(tt.test, tt.replaceWith)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "token"))
+
+		var gotMethod, gotToken string
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			gotMethod = method
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if values := md.Get("authorization"); len(values) > 0 {
+				gotToken = values[0]
+			}
+			return nil
+		}
+		if err := interceptor(ctx, tt.method, nil, nil, nil, invoker); err != nil {
+			t.Fatalf("ClientUnaryWithPackage(%q, %q) error = %v", tt.test, tt.replaceWith, err)
+		}
+		if gotMethod != tt.want {
+			t.Errorf("ClientUnaryWithPackage(%q, %q) method = %q, want %q", tt.test, tt.replaceWith, gotMethod, tt.want)
+		}
+		if gotToken != "token" {
+			t.Errorf("ClientUnaryWithPackage(%q, %q) authorization = %q, want %q", tt.test, tt.replaceWith, gotToken, "token")
+		}
+	}
+}
+
+func TestClientStreamWithPackage(t *testing.T) {
+	interceptor := utils.NewAuthInterceptor(nil).ClientStreamWithPackage("core", "core2")
+
+	var gotMethod string
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		gotMethod = method
+		return nil, nil
+	}
+	if _, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/core.v1.UserService/Watch", streamer); err != nil {
+		t.Fatalf("ClientStreamWithPackage error = %v", err)
+	}
+	if want := "/core2.v1.UserService/Watch"; gotMethod != want {
+		t.Errorf("ClientStreamWithPackage method = %q, want %q", gotMethod, want)
+	}
+}
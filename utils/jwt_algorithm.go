@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Supported jwt algorithms
+const (
+	JwtAlgRS256 = "RS256"
+	JwtAlgRS384 = "RS384"
+	JwtAlgRS512 = "RS512"
+	JwtAlgPS256 = "PS256"
+	JwtAlgES256 = "ES256"
+	JwtAlgES384 = "ES384"
+	JwtAlgEdDSA = "EdDSA"
+
+	DefaultJwtAlgorithm = JwtAlgRS256
+)
+
+// DefaultJwtAlgorithmForKey function return the natural algorithm of key: RS256, ES256 / ES384 by curve or EdDSA
+func DefaultJwtAlgorithmForKey(key crypto.PublicKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JwtAlgRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return JwtAlgES256, nil
+		case elliptic.P384():
+			return JwtAlgES384, nil
+		}
+		return "", fmt.Errorf("unsupported ecdsa curve %v", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return JwtAlgEdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported jwt key type %T", key)
+}
+
+// checkJwtAlgorithmKey function return error when key can not be used with alg
+func checkJwtAlgorithmKey(alg string, key crypto.PublicKey) error {
+	var ok bool
+	switch alg {
+	case JwtAlgRS256, JwtAlgRS384, JwtAlgRS512, JwtAlgPS256:
+		_, ok = key.(*rsa.PublicKey)
+	case JwtAlgES256:
+		k, isEc := key.(*ecdsa.PublicKey)
+		ok = isEc && k.Curve == elliptic.P256()
+	case JwtAlgES384:
+		k, isEc := key.(*ecdsa.PublicKey)
+		ok = isEc && k.Curve == elliptic.P384()
+	case JwtAlgEdDSA:
+		_, ok = key.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("unsupported jwt algorithm %v", alg)
+	}
+	if !ok {
+		return fmt.Errorf("jwt key type %T can not be used with %v", key, alg)
+	}
+	return nil
+}
+
+// publicKeyOf function return public key of private key
+func publicKeyOf(privateKey crypto.PrivateKey) (crypto.PublicKey, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported jwt private key type %T", privateKey)
+	}
+	return signer.Public(), nil
+}
+
+// ParseJwtPrivateKeyFromPEM function parse RSA (PKCS1 / PKCS8), EC or Ed25519 private key
+func ParseJwtPrivateKeyFromPEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem data")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("pem is not a supported private key")
+}
+
+// ParseJwtPublicKeyFromPEM function parse RSA, EC or Ed25519 public key or certificate
+func ParseJwtPublicKeyFromPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem data")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, errors.New("pem is not a supported public key")
+}
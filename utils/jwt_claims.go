@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountClaims struct holds typed claims of account token
+type AccountClaims struct {
+	jwt.RegisteredClaims
+	UserId      int64
+	PartnerId   int64
+	PartnerCode string
+	PartnerName string
+	// DiffHour is DiffHourNil when the token has no diffHour
+	DiffHour    float64
+	Username    string
+	FullName    string
+	DeviceId    int64
+	AccountType int32
+	Ip          string
+	// Roles and Permissions are checked by PolicyRegistry
+	Roles       []string
+	Permissions []string
+}
+
+// Claims struct holds AccountClaims and custom claims T, T fields are flat claims of token with json tags
+type Claims[T any] struct {
+	AccountClaims
+	Custom T
+}
+
+// ClaimsValidationError struct is returned when claims have wrong type, Claims lists their names
+type ClaimsValidationError struct {
+	Claims []string
+}
+
+func (e *ClaimsValidationError) Error() string {
+	return fmt.Sprintf("SYS.MSG.VALIDATION_CLAIMS_ERROR: malformed claims %v", strings.Join(e.Claims, ", "))
+}
+
+// GRPCStatus function return Unauthenticated status, used by status.FromError
+func (e *ClaimsValidationError) GRPCStatus() *status.Status {
+	return status.New(codes.Unauthenticated, e.Error())
+}
+
+// Is function make errors.Is(err, Unauthenticated) true
+func (e *ClaimsValidationError) Is(target error) bool {
+	return target == Unauthenticated
+}
+
+// NewAccountClaims function return claims of account
+func NewAccountClaims(account Account) AccountClaims {
+	claims := AccountClaims{
+		UserId:      account.Id,
+		PartnerId:   account.PartnerId,
+		PartnerCode: account.PartnerCode,
+		PartnerName: account.PartnerName,
+		DiffHour:    account.DiffHour,
+		FullName:    account.FullName,
+		DeviceId:    account.DeviceId,
+		AccountType: account.AccountType,
+		Ip:          account.Ip,
+	}
+	if account.Username != nil {
+		claims.Username = *account.Username
+	}
+	return claims
+}
+
+// Account function return Account of claims
+func (c AccountClaims) Account() Account {
+	username := c.Username
+	return Account{
+		Id:          c.UserId,
+		Username:    &username,
+		PartnerId:   c.PartnerId,
+		PartnerCode: c.PartnerCode,
+		PartnerName: c.PartnerName,
+		Ip:          c.Ip,
+		DiffHour:    c.DiffHour,
+		FullName:    c.FullName,
+		DeviceId:    c.DeviceId,
+		AccountType: c.AccountType,
+	}
+}
+
+// MapClaims function return claims in token format, numbers are written as strings
+// so services still reading them with ToInt64 keep working
+func (c AccountClaims) MapClaims() jwt.MapClaims {
+	claims := registeredMapClaims(c.RegisteredClaims)
+
+	claims["userId"] = strconv.FormatInt(c.UserId, 10)
+	claims["partnerId"] = strconv.FormatInt(c.PartnerId, 10)
+	claims["partnerName"] = c.PartnerName
+	claims["diffHour"] = fmt.Sprintf("%v", c.DiffHour)
+	claims["username"] = c.Username
+	claims["fullName"] = c.FullName
+	claims["deviceId"] = strconv.FormatInt(c.DeviceId, 10)
+	claims["accountType"] = strconv.FormatInt(int64(c.AccountType), 10)
+	claims["ip"] = c.Ip
+	if c.PartnerCode != "" {
+		claims["partnerCode"] = c.PartnerCode
+	}
+	if len(c.Roles) > 0 {
+		claims["roles"] = c.Roles
+	}
+	if len(c.Permissions) > 0 {
+		claims["permissions"] = c.Permissions
+	}
+	return claims
+}
+
+// DecodeAccountClaims function decode verified claims, numeric claims may be numbers or strings.
+// Valid claims are set even when a *ClaimsValidationError lists the malformed ones
+func DecodeAccountClaims(claims jwt.MapClaims) (AccountClaims, error) {
+	decoder := claimsDecoder{claims: claims}
+	accountType := decoder.int64("accountType")
+	if accountType != int64(int32(accountType)) {
+		decoder.malformed = append(decoder.malformed, "accountType")
+	}
+	result := AccountClaims{
+		UserId:      decoder.int64("userId"),
+		PartnerId:   decoder.int64("partnerId"),
+		PartnerCode: decoder.string("partnerCode"),
+		PartnerName: decoder.string("partnerName"),
+		DiffHour:    decoder.float64("diffHour", DiffHourNil),
+		Username:    decoder.string("username"),
+		FullName:    decoder.string("fullName"),
+		DeviceId:    decoder.int64("deviceId"),
+		AccountType: int32(accountType),
+		Ip:          decoder.string("ip"),
+		Roles:       decoder.strings("roles"),
+		Permissions: decoder.strings("permissions"),
+	}
+	if err := decodeClaimsJSON(claims, &result.RegisteredClaims); err != nil {
+		decoder.malformed = append(decoder.malformed, registeredClaimsNames...)
+	}
+	return result, decoder.err()
+}
+
+// DecodeClaims function decode verified claims into account and custom claims T
+func DecodeClaims[T any](claims jwt.MapClaims) (Claims[T], error) {
+	var result Claims[T]
+	account, err := DecodeAccountClaims(claims)
+	result.AccountClaims = account
+
+	var malformed []string
+	var validationErr *ClaimsValidationError
+	if errors.As(err, &validationErr) {
+		malformed = validationErr.Claims
+	}
+	if err := decodeClaimsJSON(claims, &result.Custom); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			malformed = append(malformed, typeErr.Field)
+		} else {
+			malformed = append(malformed, "custom")
+		}
+	}
+	if len(malformed) > 0 {
+		return result, &ClaimsValidationError{Claims: malformed}
+	}
+	return result, nil
+}
+
+// SignClaims function sign account and custom claims with manager
+func SignClaims[T any](manager *JwtManager, claims Claims[T]) (string, error) {
+	mapClaims := claims.AccountClaims.MapClaims()
+	data, err := json.Marshal(claims.Custom)
+	if err != nil {
+		return "", err
+	}
+	custom := map[string]interface{}{}
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return "", fmt.Errorf("custom claims must be a struct or map: %w", err)
+	}
+	for key, value := range custom {
+		mapClaims[key] = value
+	}
+	return manager.signToken(mapClaims)
+}
+
+// VerifyClaims function verify token and decode its account and custom claims T
+func VerifyClaims[T any](manager *JwtManager, token string) (Claims[T], error) {
+	mapClaims, err := manager.Verify(token)
+	if err != nil {
+		return Claims[T]{}, err
+	}
+	return DecodeClaims[T](*mapClaims)
+}
+
+// registeredMapClaims function return registered claims as MapClaims, empty ones are omitted
+func registeredMapClaims(registered jwt.RegisteredClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	data, _ := json.Marshal(registered)
+	json.Unmarshal(data, &claims)
+	return claims
+}
+
+var registeredClaimsNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+func decodeClaimsJSON(claims jwt.MapClaims, target interface{}) error {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// claimsDecoder struct read typed claims and collect the malformed ones
+type claimsDecoder struct {
+	claims    jwt.MapClaims
+	malformed []string
+}
+
+func (d *claimsDecoder) err() error {
+	if len(d.malformed) == 0 {
+		return nil
+	}
+	sort.Strings(d.malformed)
+	names := make([]string, 0, len(d.malformed))
+	for i, name := range d.malformed {
+		if i == 0 || name != d.malformed[i-1] {
+			names = append(names, name)
+		}
+	}
+	return &ClaimsValidationError{Claims: names}
+}
+
+func (d *claimsDecoder) string(key string) string {
+	switch value := d.claims[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64, json.Number:
+		return fmt.Sprintf("%v", value)
+	}
+	d.malformed = append(d.malformed, key)
+	return ""
+}
+
+// strings function read list claim written as array or space / comma separated string
+func (d *claimsDecoder) strings(key string) []string {
+	switch value := d.claims[key].(type) {
+	case nil:
+		return nil
+	case []string:
+		return value
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			str, ok := item.(string)
+			if !ok {
+				d.malformed = append(d.malformed, key)
+				return nil
+			}
+			result = append(result, str)
+		}
+		return result
+	}
+	d.malformed = append(d.malformed, key)
+	return nil
+}
+
+// int64 function read integer claim written as number or string, missing or empty is 0
+func (d *claimsDecoder) int64(key string) int64 {
+	switch value := d.claims[key].(type) {
+	case nil:
+		return 0
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case int64:
+		return value
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<63 {
+			return int64(value)
+		}
+	case json.Number:
+		if num, err := value.Int64(); err == nil {
+			return num
+		}
+	case string:
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return 0
+		}
+		if num, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return num
+		}
+	}
+	d.malformed = append(d.malformed, key)
+	return 0
+}
+
+// float64 function read number claim written as number or string, missing or empty is defaultVal
+func (d *claimsDecoder) float64(key string, defaultVal float64) float64 {
+	switch value := d.claims[key].(type) {
+	case nil:
+		return defaultVal
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	case json.Number:
+		if num, err := value.Float64(); err == nil {
+			return num
+		}
+	case string:
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return defaultVal
+		}
+		if num, err := strconv.ParseFloat(value, 64); err == nil {
+			return num
+		}
+	}
+	d.malformed = append(d.malformed, key)
+	return defaultVal
+}
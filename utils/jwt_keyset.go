@@ -0,0 +1,428 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JwtKey struct holds one key of JwtKeySet, PrivateKey is nil for verify only keys.
+// Keys are *rsa, *ecdsa or ed25519 keys, Alg defaults to DefaultJwtAlgorithmForKey
+type JwtKey struct {
+	Kid        string
+	Alg        string
+	PublicKey  crypto.PublicKey
+	PrivateKey crypto.PrivateKey
+}
+
+// JwtKeySet struct holds verification keys selected by kid and the current signing key
+type JwtKeySet struct {
+	mu         sync.RWMutex
+	keys       map[string]JwtKey
+	signingKid string
+}
+
+// NewJwtKeySet function create new JwtKeySet, signingKid must be a key with PrivateKey
+func NewJwtKeySet(signingKid string, keys ...JwtKey) (*JwtKeySet, error) {
+	ks := &JwtKeySet{}
+	if err := ks.Replace(signingKid, keys...); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Replace function swap all keys, used by reload
+func (ks *JwtKeySet) Replace(signingKid string, keys ...JwtKey) error {
+	keyMap := make(map[string]JwtKey, len(keys))
+	for _, key := range keys {
+		if key.Kid == "" {
+			return errors.New("JwtKey kid is empty")
+		}
+		if key.PrivateKey != nil {
+			publicKey, err := publicKeyOf(key.PrivateKey)
+			if err != nil {
+				return err
+			}
+			if key.PublicKey == nil {
+				key.PublicKey = publicKey
+			} else if !publicKeyEqual(publicKey, key.PublicKey) {
+				return fmt.Errorf("JwtKey %v public key does not match private key", key.Kid)
+			}
+		}
+		if key.PublicKey == nil {
+			return fmt.Errorf("JwtKey %v has no key", key.Kid)
+		}
+		if key.Alg == "" {
+			alg, err := DefaultJwtAlgorithmForKey(key.PublicKey)
+			if err != nil {
+				return err
+			}
+			key.Alg = alg
+		}
+		if err := checkJwtAlgorithmKey(key.Alg, key.PublicKey); err != nil {
+			return fmt.Errorf("JwtKey %v: %w", key.Kid, err)
+		}
+		keyMap[key.Kid] = key
+	}
+	if signingKid != "" {
+		if key, ok := keyMap[signingKid]; !ok || key.PrivateKey == nil {
+			return fmt.Errorf("JwtKey %v has no private key to sign", signingKid)
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keyMap
+	ks.signingKid = signingKid
+	return nil
+}
+
+// publicKeyEqual function compare public keys of rsa, ecdsa or ed25519
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// SigningKey function return current signing key
+func (ks *JwtKeySet) SigningKey() (JwtKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[ks.signingKid]
+	return key, ok && key.PrivateKey != nil
+}
+
+// Key function return key of kid
+func (ks *JwtKeySet) Key(kid string) (JwtKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// Keys function return all keys sorted by kid
+func (ks *JwtKeySet) Keys() []JwtKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	keys := make([]JwtKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+// LoadJwtKeysFromPemDir function load every <kid>.pem file of dir, private or public key
+func LoadJwtKeysFromPemDir(dir string) ([]JwtKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]JwtKey, 0, len(files))
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := LoadJwtKeyFromPemFile(kid, file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// LoadJwtKeyFromPemFile function load RSA, EC or Ed25519 private or public key from pem file
+func LoadJwtKeyFromPemFile(kid, filePath string) (JwtKey, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return JwtKey{}, err
+	}
+	if privateKey, err := ParseJwtPrivateKeyFromPEM(data); err == nil {
+		return JwtKey{Kid: kid, PrivateKey: privateKey}, nil
+	}
+	publicKey, err := ParseJwtPublicKeyFromPEM(data)
+	if err != nil {
+		return JwtKey{}, fmt.Errorf("load jwt key %v: %w", filePath, err)
+	}
+	return JwtKey{Kid: kid, PublicKey: publicKey}, nil
+}
+
+// jwk struct is a JSON Web Key (RFC 7517), RSA, EC or OKP (Ed25519)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	P string `json:"p,omitempty"`
+	Q string `json:"q,omitempty"`
+	// EC, OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	// private part of every type
+	D string `json:"d,omitempty"`
+}
+
+// Jwks struct is a JSON Web Key Set document
+type Jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeJwkBytes(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func decodeJwkInt(value string) (*big.Int, error) {
+	data, err := decodeJwkBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func encodeJwkInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// encodeJwkCoordinate function return fixed size coordinate of EC key
+func encodeJwkCoordinate(value *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, size)))
+}
+
+// LoadJwtKeysFromJwksFile function load keys from JWKS document, keys with "d" can sign
+func LoadJwtKeysFromJwksFile(filePath string) ([]JwtKey, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var doc Jwks
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	keys := make([]JwtKey, 0, len(doc.Keys))
+	for _, item := range doc.Keys {
+		key, err := item.toJwtKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %v: %w", item.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (item jwk) toJwtKey() (JwtKey, error) {
+	key := JwtKey{Kid: item.Kid, Alg: item.Alg}
+	switch item.Kty {
+	case "RSA":
+		n, errN := decodeJwkInt(item.N)
+		e, errE := decodeJwkInt(item.E)
+		if err := errors.Join(errN, errE); err != nil {
+			return key, err
+		}
+		publicKey := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		key.PublicKey = publicKey
+		if item.D != "" && item.P != "" && item.Q != "" {
+			d, errD := decodeJwkInt(item.D)
+			p, errP := decodeJwkInt(item.P)
+			q, errQ := decodeJwkInt(item.Q)
+			if err := errors.Join(errD, errP, errQ); err != nil {
+				return key, err
+			}
+			privateKey := &rsa.PrivateKey{PublicKey: *publicKey, D: d, Primes: []*big.Int{p, q}}
+			if err := privateKey.Validate(); err != nil {
+				return key, err
+			}
+			privateKey.Precompute()
+			key.PrivateKey = privateKey
+		}
+	case "EC":
+		var curve elliptic.Curve
+		switch item.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return key, fmt.Errorf("unsupported curve %v", item.Crv)
+		}
+		x, errX := decodeJwkInt(item.X)
+		y, errY := decodeJwkInt(item.Y)
+		if err := errors.Join(errX, errY); err != nil {
+			return key, err
+		}
+		point, err := checkJwkEcPoint(item.Crv, x, y)
+		if err != nil {
+			return key, err
+		}
+		publicKey := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		key.PublicKey = publicKey
+		if item.D != "" {
+			d, err := decodeJwkInt(item.D)
+			if err != nil {
+				return key, err
+			}
+			if err := checkJwkEcPrivate(item.Crv, d, point); err != nil {
+				return key, err
+			}
+			key.PrivateKey = &ecdsa.PrivateKey{PublicKey: *publicKey, D: d}
+		}
+	case "OKP":
+		if item.Crv != "Ed25519" {
+			return key, fmt.Errorf("unsupported curve %v", item.Crv)
+		}
+		x, err := decodeJwkBytes(item.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return key, errors.New("invalid Ed25519 public key")
+		}
+		key.PublicKey = ed25519.PublicKey(x)
+		if item.D != "" {
+			seed, err := decodeJwkBytes(item.D)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return key, errors.New("invalid Ed25519 private key")
+			}
+			privateKey := ed25519.NewKeyFromSeed(seed)
+			if !privateKey.Public().(ed25519.PublicKey).Equal(key.PublicKey) {
+				return key, errors.New("Ed25519 private key does not match public key")
+			}
+			key.PrivateKey = privateKey
+		}
+	default:
+		return key, fmt.Errorf("unsupported key type %v", item.Kty)
+	}
+	return key, nil
+}
+
+// jwkEcdhCurves maps JWK curve names to crypto/ecdh curves, used to validate EC keys
+var jwkEcdhCurves = map[string]ecdh.Curve{
+	"P-256": ecdh.P256(),
+	"P-384": ecdh.P384(),
+}
+
+// jwkEcCoordinateSizes maps JWK curve names to coordinate byte size
+var jwkEcCoordinateSizes = map[string]int{
+	"P-256": 32,
+	"P-384": 48,
+}
+
+// checkJwkEcPoint function return uncompressed point x, y after checking it is on curve crv
+func checkJwkEcPoint(crv string, x, y *big.Int) ([]byte, error) {
+	size := jwkEcCoordinateSizes[crv]
+	if x.Sign() < 0 || y.Sign() < 0 || len(x.Bytes()) > size || len(y.Bytes()) > size {
+		return nil, errors.New("invalid EC public key")
+	}
+	point := make([]byte, 1+2*size)
+	point[0] = 4
+	x.FillBytes(point[1 : 1+size])
+	y.FillBytes(point[1+size:])
+	if _, err := jwkEcdhCurves[crv].NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("EC public key is not on curve %v", crv)
+	}
+	return point, nil
+}
+
+// checkJwkEcPrivate function check private scalar d of curve crv matches uncompressed point
+func checkJwkEcPrivate(crv string, d *big.Int, point []byte) error {
+	size := jwkEcCoordinateSizes[crv]
+	if d.Sign() <= 0 || len(d.Bytes()) > size {
+		return errors.New("invalid EC private key")
+	}
+	privateKey, err := jwkEcdhCurves[crv].NewPrivateKey(d.FillBytes(make([]byte, size)))
+	if err != nil {
+		return errors.New("invalid EC private key")
+	}
+	if !bytes.Equal(privateKey.PublicKey().Bytes(), point) {
+		return errors.New("EC private key does not match public key")
+	}
+	return nil
+}
+
+// newPublicJwk function return public JWK of key
+func newPublicJwk(key JwtKey) jwk {
+	item := jwk{Kid: key.Kid, Use: "sig", Alg: key.Alg}
+	switch k := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		item.Kty = "RSA"
+		item.N = encodeJwkInt(k.N)
+		item.E = encodeJwkInt(big.NewInt(int64(k.E)))
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		item.Kty = "EC"
+		item.Crv = k.Curve.Params().Name
+		item.X = encodeJwkCoordinate(k.X, size)
+		item.Y = encodeJwkCoordinate(k.Y, size)
+	case ed25519.PublicKey:
+		item.Kty = "OKP"
+		item.Crv = "Ed25519"
+		item.X = base64.RawURLEncoding.EncodeToString(k)
+	}
+	return item
+}
+
+// PublicJwks function return JWKS document of public keys
+func (ks *JwtKeySet) PublicJwks() Jwks {
+	doc := Jwks{Keys: []jwk{}}
+	for _, key := range ks.Keys() {
+		doc.Keys = append(doc.Keys, newPublicJwk(key))
+	}
+	return doc
+}
+
+// JwksHandler function return http handler publishing public JWKS, e.g. on /.well-known/jwks.json
+func (ks *JwtKeySet) JwksHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(ks.PublicJwks())
+		if err != nil {
+			ResponseInternalError(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(data)
+	})
+}
+
+// StartReload function call load every interval and replace keys until ctx is done, load errors keep old keys.
+// A non positive interval returns an error without reloading
+func (ks *JwtKeySet) StartReload(ctx context.Context, interval time.Duration, load func() (string, []JwtKey, error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("JwtKeySet reload interval must be positive, got %v", interval)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			signingKid, keys, err := load()
+			if err == nil {
+				err = ks.Replace(signingKid, keys...)
+			}
+			if err != nil {
+				Logger().Error("JwtKeySet reload error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
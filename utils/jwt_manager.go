@@ -1,13 +1,14 @@
 package utils
 
 import (
+	"context"
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type Account struct {
@@ -29,16 +30,54 @@ type Account struct {
 type JwtManager struct {
 	VerifyKey *rsa.PublicKey
 	SignKey   *rsa.PrivateKey
+	// PublicKey and PrivateKey are *rsa, *ecdsa or ed25519 keys matching Algorithm, used instead of VerifyKey and SignKey when set
+	PublicKey  crypto.PublicKey
+	PrivateKey crypto.PrivateKey
+	// Algorithm is pinned on verify, default RS256
+	Algorithm string
+	// KeySet enables key rotation, tokens are signed with its signing key and carry a kid header
+	KeySet *JwtKeySet
+	// Validation is enforced by Verify and ParseToken
+	Validation JwtValidationOptions
+	// Revocation is consulted by Verify by jti, user id and device id, nil to skip
+	Revocation RevocationChecker
+}
+
+// JwtValidationOptions struct holds claims checked on verify
+type JwtValidationOptions struct {
+	// Issuer expected in "iss", also set on generated tokens, empty to skip
+	Issuer string
+	// Audiences, token "aud" must contain one of them, the first one is set on generated tokens
+	Audiences []string
+	// Leeway is the clock skew allowed on "exp", "nbf" and "iat"
+	Leeway time.Duration
+	// RequiredClaims must be present in access tokens, e.g. "exp", "userId"
+	RequiredClaims []string
 }
 
 // JwtManager global instance
 var (
 	JwtManagerInstance *JwtManager
+
+	// ErrJwtTokenType is returned when the "typ" claim does not match, e.g. a refresh token used as access token
+	ErrJwtTokenType = errors.New("SYS.MSG.VALIDATION_TOKEN_TYPE_ERROR")
+	// ErrJwtExpired is returned for tokens past "exp"
+	ErrJwtExpired = errors.New("SYS.MSG.VALIDATION_EXPIRED_ERROR")
+	// ErrJwtMalformed is returned for tokens which can not be decoded
+	ErrJwtMalformed = errors.New("SYS.MSG.VALIDATION_MALFORMED_ERROR")
+	// ErrJwtNotValidYet is returned for tokens before "nbf" or "iat"
+	ErrJwtNotValidYet = errors.New("SYS.MSG.VALIDATION_NOT_VALID_YET_ERROR")
+	// ErrJwtClaims is returned for wrong "iss", "aud" or missing required claims
+	ErrJwtClaims = errors.New("SYS.MSG.VALIDATION_CLAIMS_ERROR")
+	// ErrJwtSignature is returned for invalid signatures
+	ErrJwtSignature = errors.New("SYS.MSG.VALIDATION_SIGNATURE_ERROR")
 )
 
 // UserClaims struct holds custom jwt claim
+//
+// Deprecated: UserClaims is the string wire format only, use AccountClaims and DecodeAccountClaims
 type UserClaims struct {
-	jwt.StandardClaims
+	jwt.RegisteredClaims
 	UserID      string `json:"userId"`
 	PartnerId   string `json:"partnerId"`
 	PartnerName string `json:"partnerName"`
@@ -55,106 +94,310 @@ func NewJwtManager(signKey *rsa.PrivateKey, verifyKey *rsa.PublicKey) *JwtManage
 	return &JwtManager{
 		SignKey:   signKey,
 		VerifyKey: verifyKey,
+		Algorithm: DefaultJwtAlgorithm,
 	}
 }
 
-// Generate function return token of account
-func (manager *JwtManager) Generate(isRefreshToken bool, account Account, expDuration time.Duration) (string, error) {
-	var claims UserClaims
-
-	if isRefreshToken {
-		claims = UserClaims{
-			UserID:      fmt.Sprintf("%v", account.Id),
-			PartnerId:   fmt.Sprintf("%v", account.PartnerId),
-			PartnerName: account.PartnerName,
-			DiffHour:    fmt.Sprintf("%v", account.DiffHour),
-			Username:    *account.Username,
-			FullName:    account.FullName,
-			DeviceId:    fmt.Sprintf("%v", account.DeviceId),
-			AccountType: fmt.Sprintf("%v", account.AccountType),
-			Ip:          fmt.Sprintf("%v", account.Ip),
+// NewJwtManagerWithAlgorithm function return new *JwtManager signing with alg (RS256/384/512, PS256, ES256/384, EdDSA)
+func NewJwtManagerWithAlgorithm(alg string, signKey crypto.PrivateKey, verifyKey crypto.PublicKey) (*JwtManager, error) {
+	if verifyKey == nil && signKey != nil {
+		publicKey, err := publicKeyOf(signKey)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		claims = UserClaims{
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(expDuration).Unix(),
-			},
-			UserID:      fmt.Sprintf("%v", account.Id),
-			PartnerId:   fmt.Sprintf("%v", account.PartnerId),
-			PartnerName: account.PartnerName,
-			DiffHour:    fmt.Sprintf("%v", account.DiffHour),
-			Username:    *account.Username,
-			FullName:    account.FullName,
-			DeviceId:    fmt.Sprintf("%v", account.DeviceId),
-			AccountType: fmt.Sprintf("%v", account.AccountType),
-			Ip:          fmt.Sprintf("%v", account.Ip),
+		verifyKey = publicKey
+	}
+	if verifyKey != nil {
+		if err := checkJwtAlgorithmKey(alg, verifyKey); err != nil {
+			return nil, err
 		}
 	}
+	manager := &JwtManager{
+		PublicKey:  verifyKey,
+		PrivateKey: signKey,
+		Algorithm:  alg,
+	}
+	// keep VerifyKey and SignKey filled for code reading rsa keys
+	manager.VerifyKey, _ = verifyKey.(*rsa.PublicKey)
+	manager.SignKey, _ = signKey.(*rsa.PrivateKey)
+	return manager, nil
+}
 
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("RS256"), claims)
-	return token.SignedString(manager.SignKey)
+// signKey function return PrivateKey, SignKey otherwise
+func (manager *JwtManager) signKey() crypto.PrivateKey {
+	if manager.PrivateKey != nil {
+		return manager.PrivateKey
+	}
+	if manager.SignKey != nil {
+		return manager.SignKey
+	}
+	return nil
 }
 
-// Verify function return UserClaims of given token
-func (manager *JwtManager) Verify(token string) (*jwt.MapClaims, error) {
-	index := strings.Index(token, "|||")
-	if index >= 0 {
-		token = token[:index]
+// publicKey function return PublicKey, VerifyKey otherwise
+func (manager *JwtManager) publicKey() crypto.PublicKey {
+	if manager.PublicKey != nil {
+		return manager.PublicKey
 	}
-	j, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if manager == nil {
-			return nil, errors.New("JwtManager is nil")
-		}
-		if manager.VerifyKey == nil {
-			return nil, errors.New("JwtManager.VerifyKey is nil")
+	if manager.VerifyKey != nil {
+		return manager.VerifyKey
+	}
+	return nil
+}
+
+// NewJwtManagerWithKeySet function return new *JwtManager using rotating keys
+func NewJwtManagerWithKeySet(keySet *JwtKeySet) *JwtManager {
+	return &JwtManager{
+		KeySet: keySet,
+	}
+}
+
+// algorithm function return pinned algorithm of manager keys
+func (manager *JwtManager) algorithm() string {
+	if manager.Algorithm == "" {
+		return DefaultJwtAlgorithm
+	}
+	return manager.Algorithm
+}
+
+// signToken function sign claims with current signing key
+func (manager *JwtManager) signToken(claims jwt.Claims) (string, error) {
+	if manager.KeySet != nil {
+		key, ok := manager.KeySet.SigningKey()
+		if !ok {
+			return "", errors.New("JwtManager.KeySet has no signing key")
 		}
-		return manager.VerifyKey, nil
-	})
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+		token.Header["kid"] = key.Kid
+		return token.SignedString(key.PrivateKey)
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(manager.algorithm()), claims)
+	return token.SignedString(manager.signKey())
+}
+
+// verifyKey function return verification key of token, selected by kid header when KeySet is set.
+// The token alg header must match the algorithm of the key, so it can not be downgraded
+func (manager *JwtManager) verifyKey(token *jwt.Token) (interface{}, error) {
+	if manager == nil {
+		return nil, errors.New("JwtManager is nil")
+	}
+	alg, key, err := manager.selectVerifyKey(token)
 	if err != nil {
-		if errorValue, ok := err.(*jwt.ValidationError); ok {
-			switch errorValue.Errors {
-			case jwt.ValidationErrorExpired:
-				return nil, errors.New("SYS.MSG.VALIDATION_EXPIRED_ERROR")
-			case jwt.ValidationErrorMalformed:
-				return nil, errors.New("SYS.MSG.VALIDATION_MALFORMED_ERROR")
+		return nil, err
+	}
+	if token.Method == nil || token.Method.Alg() != alg {
+		return nil, fmt.Errorf("unexpected jwt algorithm %v", token.Header["alg"])
+	}
+	return key, nil
+}
+
+func (manager *JwtManager) selectVerifyKey(token *jwt.Token) (string, crypto.PublicKey, error) {
+	if manager.KeySet != nil {
+		if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+			key, ok := manager.KeySet.Key(kid)
+			if !ok {
+				return "", nil, fmt.Errorf("unknown jwt kid %v", kid)
+			}
+			return key.Alg, key.PublicKey, nil
+		}
+		// token issued before key rotation was enabled
+		if manager.publicKey() == nil {
+			if key, ok := manager.KeySet.SigningKey(); ok {
+				return key.Alg, key.PublicKey, nil
 			}
 		}
-		return nil, err
+	}
+	publicKey := manager.publicKey()
+	if publicKey == nil {
+		return "", nil, errors.New("JwtManager.VerifyKey is nil")
+	}
+	return manager.algorithm(), publicKey, nil
+}
+
+// Generate function return token of account, expDuration is ignored for refresh tokens which carry typ "refresh"
+// and expire after DefaultRefreshTokenTTL. Use GenerateRefresh for another ttl, RefreshTokenManager to rotate and revoke them
+func (manager *JwtManager) Generate(isRefreshToken bool, account Account, expDuration time.Duration) (string, error) {
+	if isRefreshToken {
+		return manager.GenerateRefresh(account, DefaultRefreshTokenTTL)
+	}
+	return manager.generate(false, account, expDuration)
+}
+
+// GenerateRefresh function return refresh token of account expiring after ttl, DefaultRefreshTokenTTL when not positive
+func (manager *JwtManager) GenerateRefresh(account Account, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+	return manager.generate(true, account, ttl)
+}
+
+func (manager *JwtManager) generate(isRefreshToken bool, account Account, expDuration time.Duration) (string, error) {
+	claims := NewAccountClaims(account)
+	claims.RegisteredClaims = manager.registeredClaims()
+	claims.ID = NewTokenId()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expDuration))
+
+	mapClaims := claims.MapClaims()
+	if isRefreshToken {
+		mapClaims["typ"] = refreshTokenType
+	}
+	return manager.signToken(mapClaims)
+}
+
+// registeredClaims function return iss and aud of generated tokens
+func (manager *JwtManager) registeredClaims() jwt.RegisteredClaims {
+	claims := jwt.RegisteredClaims{
+		Issuer:   manager.Validation.Issuer,
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}
+	if len(manager.Validation.Audiences) > 0 {
+		claims.Audience = jwt.ClaimStrings{manager.Validation.Audiences[0]}
+	}
+	return claims
+}
+
+// parse function verify signature and validation options of token
+func (manager *JwtManager) parse(token string) (jwt.MapClaims, error) {
+	if manager == nil {
+		return nil, errors.New("JwtManager is nil")
+	}
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(manager.Validation.Leeway),
+	}
+	if manager.Validation.Issuer != "" {
+		options = append(options, jwt.WithIssuer(manager.Validation.Issuer))
+	}
+	if len(manager.Validation.Audiences) > 0 {
+		options = append(options, jwt.WithAudience(manager.Validation.Audiences...))
+	}
+	j, err := jwt.Parse(token, manager.verifyKey, options...)
+	if err != nil {
+		return nil, jwtValidationError(err)
 	}
 	claims, ok := j.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, errors.New("invalid JWT claims format")
 	}
+	return claims, nil
+}
+
+// requireClaims function check Validation.RequiredClaims, enforced on access tokens only
+func (manager *JwtManager) requireClaims(claims jwt.MapClaims) error {
+	for _, claim := range manager.Validation.RequiredClaims {
+		if _, ok := claims[claim]; !ok {
+			return ErrJwtClaims
+		}
+		// exp and nbf are validated by parse when present, iat only when required
+		if claim == "iat" {
+			iat, err := claims.GetIssuedAt()
+			if err != nil || iat == nil {
+				return ErrJwtClaims
+			}
+			if iat.After(time.Now().Add(manager.Validation.Leeway)) {
+				return ErrJwtNotValidYet
+			}
+		}
+	}
+	return nil
+}
+
+// claimsTokenType function return tokenType of claims, refresh for legacy refresh tokens without "typ" and "exp"
+func claimsTokenType(claims jwt.MapClaims) string {
+	typ := tokenType(claims)
+	if _, ok := claims["exp"]; typ == "" && !ok {
+		return refreshTokenType
+	}
+	return typ
+}
+
+// tokenType function return "typ" claim, access tokens have none
+func tokenType(claims jwt.MapClaims) string {
+	value, ok := claims["typ"]
+	if !ok || value == nil {
+		return ""
+	}
+	if typ, ok := value.(string); ok {
+		return typ
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// jwtValidationError function map jwt error to SYS.MSG.VALIDATION_* error
+func jwtValidationError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrJwtExpired
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrJwtMalformed
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrJwtNotValidYet
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer), errors.Is(err, jwt.ErrTokenInvalidAudience), errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return ErrJwtClaims
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrJwtSignature
+	}
+	return err
+}
+
+// Verify function return claims of access token, refresh and service tokens are rejected
+func (manager *JwtManager) Verify(token string) (*jwt.MapClaims, error) {
+	return manager.verify(token, "")
+}
+
+// VerifyRefresh function return claims of refresh token made by Generate(true, ...),
+// including legacy refresh tokens without "typ" and "exp"
+func (manager *JwtManager) VerifyRefresh(token string) (*jwt.MapClaims, error) {
+	return manager.verify(token, refreshTokenType)
+}
+
+// verify function return claims of token whose "typ" claim is typ, empty for access tokens.
+// Tokens without "typ" and "exp" are legacy refresh tokens
+func (manager *JwtManager) verify(token string, typ string) (*jwt.MapClaims, error) {
+	token, _ = splitTokenSuffix(token)
+	claims, err := manager.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if claimsTokenType(claims) != typ {
+		return nil, ErrJwtTokenType
+	}
+	if typ == "" {
+		if err := manager.requireClaims(claims); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkRevocation(context.Background(), manager.Revocation, claims); err != nil {
+		return nil, err
+	}
 	return &claims, nil
 }
 
 func (manager *JwtManager) MakeToken(data map[string]interface{}, expDuration time.Duration) (string, error) {
-	claims := &jwt.MapClaims{
-		"iss":  "issuer",
+	issuer := manager.Validation.Issuer
+	if issuer == "" {
+		issuer = "issuer"
+	}
+	claims := jwt.MapClaims{
+		"iss":  issuer,
+		"iat":  time.Now().Unix(),
 		"exp":  time.Now().Add(expDuration).Unix(),
 		"data": data,
 	}
+	if len(manager.Validation.Audiences) > 0 {
+		claims["aud"] = manager.Validation.Audiences[0]
+	}
 
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("RS256"), claims)
-	return token.SignedString(manager.SignKey)
+	return manager.signToken(claims)
 }
 
 func (manager *JwtManager) ParseToken(tokenString string) (map[string]interface{}, error) {
-	j, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return manager.VerifyKey, nil
-	})
-
+	claims, err := manager.parse(tokenString)
 	if err != nil {
-		errorValue, _ := err.(*jwt.ValidationError)
-		switch errorValue.Errors {
-		case jwt.ValidationErrorExpired:
-			return nil, errors.New("SYS.MSG.VALIDATION_EXPIRED_ERROR")
-		case jwt.ValidationErrorMalformed:
-			return nil, errors.New("SYS.MSG.VALIDATION_MALFORMED_ERROR")
-		}
 		return nil, err
 	}
-	claims := j.Claims.(jwt.MapClaims)
-	data := claims["data"].(map[string]interface{})
+	data, ok := claims["data"].(map[string]interface{})
+	if !ok {
+		return nil, ErrJwtMalformed
+	}
 	return data, nil
 }
@@ -3,12 +3,10 @@ package utils
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc/metadata"
-	// "suntech.com.vn/skylib/skylog.git/skylog"
 )
 
 const (
@@ -53,78 +51,55 @@ func GetLoginAccessToken(ctx context.Context) (string, string, error) {
 		return "", "", err
 	}
 
-	prefix := "Bearer "
-	splitter := "|||"
-	suffix := ""
-	accessToken := strings.Replace(fullAccessToken, prefix, "", 1)
-	index := strings.Index(accessToken, splitter)
-	if index >= 0 {
-		suffix = accessToken[len(splitter)+index:]
-		accessToken = accessToken[:index]
-	}
+	accessToken, suffix := splitTokenSuffix(strings.Replace(fullAccessToken, "Bearer ", "", 1))
 	return accessToken, suffix, nil
 }
 
 func getTokenSuffix(accessToken string) (string, string, error) {
-	splitter := "|||"
-	suffix := ""
-	index := strings.Index(accessToken, splitter)
-	if index >= 0 {
-		suffix = accessToken[len(splitter)+index:]
-		accessToken = accessToken[:index]
-	}
+	accessToken, suffix := splitTokenSuffix(accessToken)
 	return accessToken, suffix, nil
 }
 
 // GetUserClaims function return user id from context and jwt manager
 func GetUserClaims(ctx context.Context) (*jwt.MapClaims, error) {
+	if principal, ok, err := userPrincipalFromContext(ctx); ok {
+		if err != nil {
+			return nil, err
+		}
+		return &principal.Claims, nil
+	}
 	accessToken, _, err := GetLoginAccessToken(ctx)
 	if err != nil {
-		// skylog.Info("error in GetLoginAccessToken")
-		fmt.Println("error in GetLoginAccessToken")
+		Logger().DebugContext(ctx, "GetUserClaims missing access token", "error", err)
 		return nil, err
 	}
-	// skylog.Info("goto GetUserClaimsFromToken")
-	fmt.Println("goto GetUserClaimsFromToken")
 	return GetUserClaimsFromToken(accessToken)
 }
 
-// ToAccount convert from user claims to Account
+// ToAccount convert from user claims to Account, malformed claims are zero, use DecodeAccountClaims to get their error
 func ToAccount(claims *jwt.MapClaims) Account {
-	userID, _ := ToInt64((*claims)["userId"])
-	partnerId, _ := ToInt64((*claims)["partnerId"])
-	partnerCode, _ := ToString((*claims)["partnerCode"])
-	partnerName, _ := ToString((*claims)["partnerName"])
-	ip, _ := ToString((*claims)["ip"])
-	diffHour := ToFloat64WithDefault((*claims)["diffHour"], DiffHourNil)
-	username, _ := ToString((*claims)["username"])
-	fullName, _ := ToString((*claims)["fullName"])
-	deviceId, _ := ToInt64((*claims)["deviceId"])
-	accountType, _ := ToInt32((*claims)["accountType"])
-
-	return Account{
-		Id:          userID,
-		Username:    &username,
-		PartnerId:   partnerId,
-		PartnerCode: partnerCode,
-		PartnerName: partnerName,
-		Ip:          ip,
-		DiffHour:    diffHour,
-		FullName:    fullName,
-		DeviceId:    deviceId,
-		AccountType: accountType,
-	}
+	accountClaims, _ := DecodeAccountClaims(*claims)
+	return accountClaims.Account()
 }
 
 // GetAccountInfo function return Account from context and jwt manager
 func GetAccountInfo(ctx context.Context) (Account, error) {
+	if principal, ok, err := userPrincipalFromContext(ctx); ok {
+		if err != nil {
+			return Account{}, err
+		}
+		return principal.Account(), nil
+	}
 	userClaims, err := GetUserClaims(ctx)
-
 	if err != nil {
 		return Account{}, err
 	}
 
-	return ToAccount(userClaims), nil
+	accountClaims, err := DecodeAccountClaims(*userClaims)
+	if err != nil {
+		return Account{}, err
+	}
+	return accountClaims.Account(), nil
 }
 
 // GetAccountInfoFromToken function return Account from token
@@ -134,154 +109,80 @@ func GetAccountInfoFromToken(token string) (Account, error) {
 		return Account{}, err
 	}
 
-	return ToAccount(userClaims), nil
-}
-
-// GetLoginInfo function return userID, companyID, branchID, departmentID
-func GetLoginInfo(ctx context.Context) (int64, int64, int64, int64, error) {
-	accessToken, suffix, err := GetLoginAccessToken(ctx)
+	accountClaims, err := DecodeAccountClaims(*userClaims)
 	if err != nil {
-		return 0, 0, 0, 0, err
-	}
-
-	var companyID, branchID, departmentID int64
-	if len(suffix) > 0 {
-		parts := strings.Split(suffix, "|")
-		if len(parts) >= 3 {
-			companyID, _ = ToInt64(parts[0])
-			branchID, _ = ToInt64(parts[1])
-			departmentID, _ = ToInt64(parts[2])
-		}
+		return Account{}, err
 	}
+	return accountClaims.Account(), nil
+}
 
+// decodeLoginInfo function verify accessToken and return LoginInfo of its claims and session context
+func decodeLoginInfo(accessToken, sessionContext string) (LoginInfo, error) {
 	userClaims, err := GetUserClaimsFromToken(accessToken)
 	if err != nil {
-		return 0, 0, 0, 0, Unauthenticated
+		return LoginInfo{}, Unauthenticated
 	}
-	userID, err := ToInt64((*userClaims)["userId"])
+	principal, err := newPrincipal(accessToken, *userClaims, sessionContext)
 	if err != nil {
-		return 0, 0, 0, 0, Unauthenticated
+		return LoginInfo{}, err
 	}
-
-	return userID, companyID, branchID, departmentID, nil
+	return principal.LoginInfo(), nil
 }
 
-// GetLoginInfoV2 function return struct { UserID, CompanyID, BranchID, DepartmentID}
-func GetLoginInfoV2(ctx context.Context) (LoginInfo, error) {
-	accessToken, suffix, err := GetLoginAccessToken(ctx)
+// GetLoginInfo function return userID, companyID, branchID, departmentID
+func GetLoginInfo(ctx context.Context) (int64, int64, int64, int64, error) {
+	loginInfo, err := GetLoginInfoV2(ctx)
 	if err != nil {
-		return LoginInfo{}, err
+		return 0, 0, 0, 0, err
 	}
 
-	var companyID, branchID, departmentID int64
-	var diffHour float64 = DiffHourNil
-	if len(suffix) > 0 {
-		parts := strings.Split(suffix, "|")
-		if len(parts) >= 3 {
-			companyID, _ = ToInt64(parts[0])
-			branchID, _ = ToInt64(parts[1])
-			departmentID, _ = ToInt64(parts[2])
+	return loginInfo.UserId, loginInfo.CompanyId, loginInfo.BranchId, loginInfo.DepartmentId, nil
+}
 
-			if len(parts) >= 4 {
-				diffHour = ToFloat64WithDefault(parts[3], DiffHourNil)
-			}
+// GetLoginInfoV2 function return struct { UserID, CompanyID, BranchID, DepartmentID},
+// session context is read from SessionContextHeader or token suffix
+func GetLoginInfoV2(ctx context.Context) (LoginInfo, error) {
+	if principal, ok, err := userPrincipalFromContext(ctx); ok {
+		if err != nil {
+			return LoginInfo{}, err
 		}
+		return principal.LoginInfo(), nil
 	}
-
-	userClaims, err := GetUserClaimsFromToken(accessToken)
-	if err != nil {
-		return LoginInfo{}, Unauthenticated
-	}
-	userID, err := ToInt64((*userClaims)["userId"])
-	username, _ := ToString((*userClaims)["username"])
+	accessToken, suffix, err := GetLoginAccessToken(ctx)
 	if err != nil {
-		return LoginInfo{}, Unauthenticated
+		return LoginInfo{}, err
 	}
-	partnerId, _ := ToInt64((*userClaims)["partnerId"])
-	partnerCode, _ := ToString((*userClaims)["partnerCode"])
-	partnerName, _ := ToString((*userClaims)["partnerName"])
-	ip, _ := ToString((*userClaims)["ip"])
-	deviceId, _ := ToInt64((*userClaims)["deviceId"])
-	accountType, _ := ToInt32((*userClaims)["accountType"])
 
-	return LoginInfo{
-		UserId:       userID,
-		Username:     username,
-		CompanyId:    companyID,
-		BranchId:     branchID,
-		DepartmentId: departmentID,
-		PartnerId:    partnerId,
-		PartnerCode:  partnerCode,
-		Ip:           ip,
-		PartnerName:  partnerName,
-		DiffHour:     diffHour,
-		DeviceId:     deviceId,
-		AccountType:  accountType,
-	}, nil
+	return decodeLoginInfo(accessToken, sessionContextValue(ctx, suffix))
 }
 
 func DecodeToken(accessToken string) (LoginInfo, error) {
-	_, suffix, err := getTokenSuffix(accessToken)
-
+	accessToken, suffix, err := getTokenSuffix(accessToken)
 	if err != nil {
 		return LoginInfo{}, err
 	}
 
-	var companyID, branchID, departmentID int64
-	var diffHour float64 = DiffHourNil
-	if len(suffix) > 0 {
-		parts := strings.Split(suffix, "|")
-		if len(parts) >= 3 {
-			companyID, _ = ToInt64(parts[0])
-			branchID, _ = ToInt64(parts[1])
-			departmentID, _ = ToInt64(parts[2])
-
-			if len(parts) >= 4 {
-				diffHour = ToFloat64WithDefault(parts[3], DiffHourNil)
-			}
-		}
-	}
-
-	userClaims, err := GetUserClaimsFromToken(accessToken)
-	if err != nil {
-		return LoginInfo{}, Unauthenticated
-	}
-	userID, err := ToInt64((*userClaims)["userId"])
-	username, _ := ToString((*userClaims)["username"])
-	if err != nil {
-		return LoginInfo{}, Unauthenticated
-	}
-	partnerId, _ := ToInt64((*userClaims)["partnerId"])
-	partnerCode, _ := ToString((*userClaims)["partnerCode"])
-	partnerName, _ := ToString((*userClaims)["partnerName"])
-	deviceId, _ := ToInt64((*userClaims)["deviceId"])
-	accountType, _ := ToInt32((*userClaims)["accountType"])
-
-	return LoginInfo{
-		UserId:       userID,
-		Username:     username,
-		CompanyId:    companyID,
-		BranchId:     branchID,
-		DepartmentId: departmentID,
-		PartnerId:    partnerId,
-		PartnerCode:  partnerCode,
-		PartnerName:  partnerName,
-		DiffHour:     diffHour,
-		DeviceId:     deviceId,
-		AccountType:  accountType,
-	}, nil
+	return decodeLoginInfo(accessToken, suffix)
 }
 
 // GetUserID function return user id from context
 func GetUserID(ctx context.Context) (int64, error) {
+	if principal, ok, err := userPrincipalFromContext(ctx); ok {
+		if err != nil {
+			return 0, err
+		}
+		return principal.UserId, nil
+	}
 	userClaims, err := GetUserClaims(ctx)
 	if err != nil {
-		// skylog.Error(err)
-		fmt.Println(err.Error())
+		Logger().DebugContext(ctx, "GetUserID get user claims error", "error", err)
+		return 0, err
+	}
+	accountClaims, err := DecodeAccountClaims(*userClaims)
+	if err != nil {
 		return 0, err
 	}
-	userID, _ := ToInt64((*userClaims)["userId"])
-	return userID, nil
+	return accountClaims.UserId, nil
 }
 
 // GetUserIDFromToken function return user id from token
@@ -291,7 +192,11 @@ func GetUserIDFromToken(token string) (int64, error) {
 		return 0, err
 	}
 
-	return ToInt64((*userClaims)["userId"])
+	accountClaims, err := DecodeAccountClaims(*userClaims)
+	if err != nil {
+		return 0, err
+	}
+	return accountClaims.UserId, nil
 }
 
 // GetUserClaimsFromToken function return user claims from token
@@ -301,8 +206,7 @@ func GetUserClaimsFromToken(token string) (*jwt.MapClaims, error) {
 	}
 	claims, err := JwtManagerInstance.Verify(token)
 	if err != nil {
-		// skylog.Error(err)
-		fmt.Println(err.Error())
+		Logger().Debug("GetUserClaimsFromToken verify token error", "error", err)
 		return nil, err
 	}
 	if claims == nil {
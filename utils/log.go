@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Attribute keys added by the package logger from the request context
+const (
+	LogRequestIdKey = "request_id"
+	LogUserIdKey    = "user_id"
+	LogServiceKey   = "service"
+	LogMethodKey    = "method"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+var (
+	// LogMaxBodySize is the number of bytes of a request or response body kept in logs
+	LogMaxBodySize = 1024
+	// LogSensitiveKeys are redacted from logged params and bodies, a key matches when it contains one of them
+	// ignoring case, "-" and "_"
+	LogSensitiveKeys = []string{"password", "passwd", "token", "secret", "authorization", "apikey", "privatekey", "credential", "otp", "pin"}
+	// logRedacted replaces sensitive values
+	logRedacted = "***"
+)
+
+func init() {
+	SetLogHandler(slog.NewTextHandler(os.Stderr, nil))
+}
+
+// Logger function return the package logger, log with the *Context methods to get request attributes
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// SetLogHandler function set handler of the package logger, e.g. slog.NewJSONHandler(os.Stdout, nil)
+func SetLogHandler(handler slog.Handler) {
+	logger.Store(slog.New(&contextLogHandler{Handler: handler}))
+}
+
+type logAttrsContextKey struct{}
+
+// ContextWithLogAttrs function return ctx carrying attrs added to every log of the package logger with ctx
+func ContextWithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(logAttrsContextKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(parent)+len(attrs))
+	merged = append(append(merged, parent...), attrs...)
+	return context.WithValue(ctx, logAttrsContextKey{}, merged)
+}
+
+// LogAttrsFromContext function return request attributes of ctx: attrs of ContextWithLogAttrs,
+// request id of incoming metadata, gRPC method and user id or service of the Principal
+func LogAttrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(logAttrsContextKey{}).([]slog.Attr)
+	attrs = append([]slog.Attr(nil), attrs...)
+	has := func(key string) bool {
+		for _, attr := range attrs {
+			if attr.Key == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !has(LogRequestIdKey) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, key := range []string{"x-request-id", "x-correlation-id"} {
+				if values := md.Get(key); len(values) > 0 && values[0] != "" {
+					attrs = append(attrs, slog.String(LogRequestIdKey, values[0]))
+					break
+				}
+			}
+		}
+	}
+	if !has(LogMethodKey) {
+		if method, ok := grpc.Method(ctx); ok {
+			attrs = append(attrs, slog.String(LogMethodKey, method))
+		}
+	}
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		if principal.IsUser() && !has(LogUserIdKey) {
+			attrs = append(attrs, slog.Int64(LogUserIdKey, principal.LoginInfo().UserId))
+		} else if !principal.IsUser() && !has(LogServiceKey) {
+			attrs = append(attrs, slog.String(LogServiceKey, principal.ServiceName))
+		}
+	}
+	return attrs
+}
+
+// isSensitiveLogKey function check key against LogSensitiveKeys, "otp" and "pin" must match the whole key
+func isSensitiveLogKey(key string) bool {
+	key = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+	for _, sensitive := range LogSensitiveKeys {
+		if sensitive == "otp" || sensitive == "pin" {
+			if key == sensitive {
+				return true
+			}
+		} else if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactLogValue function return json form of value with sensitive fields replaced, for logs
+func RedactLogValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%T", value)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Sprintf("%T", value)
+	}
+	return redactLogJson(decoded)
+}
+
+func redactLogJson(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveLogKey(key) {
+				v[key] = logRedacted
+			} else {
+				v[key] = redactLogJson(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactLogJson(item)
+		}
+	}
+	return value
+}
+
+// logBody function return body for logs, json bodies are redacted, then truncated to LogMaxBodySize
+func logBody(data []byte) string {
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err == nil {
+		if redacted, err := json.Marshal(redactLogJson(decoded)); err == nil {
+			data = redacted
+		}
+	}
+	if LogMaxBodySize > 0 && len(data) > LogMaxBodySize {
+		return fmt.Sprintf("%s...(%d bytes)", data[:LogMaxBodySize], len(data))
+	}
+	return string(data)
+}
+
+// contextLogHandler struct add LogAttrsFromContext to records
+type contextLogHandler struct {
+	slog.Handler
+}
+
+func (h *contextLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(LogAttrsFromContext(ctx)...)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextLogHandler) WithGroup(name string) slog.Handler {
+	return &contextLogHandler{Handler: h.Handler.WithGroup(name)}
+}
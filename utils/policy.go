@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+)
+
+// MethodPolicy struct holds requirements of a gRPC method, empty fields are not checked
+type MethodPolicy struct {
+	// Method is "/package.Service/Method", "/package.Service/*" or "*"
+	Method string `mapstructure:"method"`
+	// Roles, principal must have one of them
+	Roles []string `mapstructure:"roles"`
+	// Permissions, principal must have all of them
+	Permissions []string `mapstructure:"permissions"`
+	// AccountTypes, principal AccountType must be one of them
+	AccountTypes []int32 `mapstructure:"accountTypes"`
+	// PartnerIds, principal PartnerId must be one of them
+	PartnerIds []int64 `mapstructure:"partnerIds"`
+	// RequirePartner, principal must belong to a partner (PartnerId != 0)
+	RequirePartner bool `mapstructure:"requirePartner"`
+}
+
+// PermissionProvider interface return roles and permissions of principal, e.g. from database.
+// Without provider they are read from "roles" and "permissions" claims
+type PermissionProvider interface {
+	Permissions(ctx context.Context, principal *Principal) (roles []string, permissions []string, err error)
+}
+
+// PolicyRegistry struct holds MethodPolicy by method
+type PolicyRegistry struct {
+	mu       sync.RWMutex
+	policies map[string]MethodPolicy
+	// Provider is optional
+	Provider PermissionProvider
+}
+
+// NewPolicyRegistry function create new PolicyRegistry
+func NewPolicyRegistry(policies ...MethodPolicy) *PolicyRegistry {
+	registry := &PolicyRegistry{
+		policies: map[string]MethodPolicy{},
+	}
+	for _, policy := range policies {
+		registry.Register(policy)
+	}
+	return registry
+}
+
+// LoadPolicyRegistryFromFile function load policies from a config file (yaml, json, toml...):
+//
+//	policies:
+//	  - method: /core.v1.UserService/DeleteUser
+//	    roles: [admin]
+//	    permissions: [user.delete]
+//	  - method: /partner.v1.OrderService/*
+//	    accountTypes: [2]
+//	    requirePartner: true
+func LoadPolicyRegistryFromFile(filePath string) (*PolicyRegistry, error) {
+	config := viper.New()
+	config.SetConfigFile(filePath)
+	if err := config.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var policies []MethodPolicy
+	if err := config.UnmarshalKey("policies", &policies); err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		if policy.Method == "" {
+			return nil, fmt.Errorf("policy file %v: method is required", filePath)
+		}
+	}
+	return NewPolicyRegistry(policies...), nil
+}
+
+// Register function set policy of policy.Method
+func (r *PolicyRegistry) Register(policy MethodPolicy) *PolicyRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[policy.Method] = policy
+	return r
+}
+
+// Policy function return policy of full method, exact match first then "/package.Service/*" then "*"
+func (r *PolicyRegistry) Policy(method string) (MethodPolicy, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if policy, ok := r.policies[method]; ok {
+		return policy, true
+	}
+	if index := strings.LastIndex(method, "/"); index > 0 {
+		if policy, ok := r.policies[method[:index]+"/*"]; ok {
+			return policy, true
+		}
+	}
+	policy, ok := r.policies["*"]
+	return policy, ok
+}
+
+// PermissionDeniedError function return PermissionDenied error naming what is missing,
+// kind is "permission", "role", "account_type" or "partner"
+func PermissionDeniedError(kind string, name string) error {
+	return CustomError(codes.PermissionDenied, fmt.Sprintf("SYS.MSG.MISSING_%v_ERROR", strings.ToUpper(kind)), name, nil)
+}
+
+// Authorize function check principal against policy of method, methods without policy are allowed
+func (r *PolicyRegistry) Authorize(ctx context.Context, method string, principal *Principal) error {
+	policy, ok := r.Policy(method)
+	if !ok {
+		return nil
+	}
+	if principal == nil {
+		return Unauthenticated
+	}
+
+	if len(policy.AccountTypes) > 0 && !containsValue(policy.AccountTypes, principal.AccountType) {
+		return PermissionDeniedError("account_type", fmt.Sprintf("%v", policy.AccountTypes))
+	}
+	if policy.RequirePartner && principal.PartnerId == 0 {
+		return PermissionDeniedError("partner", "partnerId")
+	}
+	if len(policy.PartnerIds) > 0 && !containsValue(policy.PartnerIds, principal.PartnerId) {
+		return PermissionDeniedError("partner", fmt.Sprintf("%v", policy.PartnerIds))
+	}
+	if len(policy.Roles) == 0 && len(policy.Permissions) == 0 {
+		return nil
+	}
+
+	roles, permissions := principal.Roles, principal.Permissions
+	if r.Provider != nil {
+		var err error
+		roles, permissions, err = r.Provider.Permissions(ctx, principal)
+		if err != nil {
+			return err
+		}
+	}
+	if len(policy.Roles) > 0 && !containsAny(roles, policy.Roles) {
+		return PermissionDeniedError("role", strings.Join(policy.Roles, "|"))
+	}
+	for _, permission := range policy.Permissions {
+		if !containsValue(permissions, permission) {
+			return PermissionDeniedError("permission", permission)
+		}
+	}
+	return nil
+}
+
+func containsValue[T comparable](values []T, value T) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if containsValue(values, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of Principal
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+	PrincipalApiKey  = "api_key"
+)
+
+var (
+	//NotUserPrincipalError error, returned by user getters when the caller is a service or an api key
+	NotUserPrincipalError = status.Error(codes.PermissionDenied, "SYS.MSG.NOT_USER_PRINCIPAL_ERROR")
+)
+
+// Principal struct is the verified caller of a request, attached to context by AuthInterceptor
+type Principal struct {
+	AccountClaims
+	// Kind is PrincipalUser for a human Account, PrincipalService or PrincipalApiKey otherwise
+	Kind string
+	// ServiceName is the "sub" of a service token or the name of an api key
+	ServiceName string
+	// ApiKeyId is the id of the api key
+	ApiKeyId       string
+	SessionContext SessionContext
+	// Token is the verified access token without session context suffix
+	Token string
+	// Claims are the raw verified claims
+	Claims jwt.MapClaims
+}
+
+type principalContextKey struct{}
+
+// ContextWithPrincipal function return ctx carrying principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext function return principal attached by AuthInterceptor
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// newPrincipal function return principal of verified claims and encoded session context
+func newPrincipal(token string, claims jwt.MapClaims, sessionContext string) (*Principal, error) {
+	// refresh and service tokens, and legacy refresh tokens without "exp", are not user access tokens
+	if claimsTokenType(claims) != "" {
+		return nil, Unauthenticated
+	}
+	accountClaims, err := DecodeAccountClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := DecodeSessionContext(accountClaims.UserId, sessionContext)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		AccountClaims:  accountClaims,
+		Kind:           PrincipalUser,
+		SessionContext: sc,
+		Token:          token,
+		Claims:         claims,
+	}, nil
+}
+
+// IsUser function return true when principal is a human Account
+func (p *Principal) IsUser() bool {
+	return p.Kind == PrincipalUser
+}
+
+// userPrincipalFromContext function return user principal of ctx, NotUserPrincipalError for other kinds
+func userPrincipalFromContext(ctx context.Context) (*Principal, bool, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, false, nil
+	}
+	if !principal.IsUser() {
+		return nil, true, NotUserPrincipalError
+	}
+	return principal, true, nil
+}
+
+// LoginInfo function return LoginInfo of principal
+func (p *Principal) LoginInfo() LoginInfo {
+	return LoginInfo{
+		UserId:       p.UserId,
+		Username:     p.Username,
+		CompanyId:    p.SessionContext.CompanyId,
+		BranchId:     p.SessionContext.BranchId,
+		DepartmentId: p.SessionContext.DepartmentId,
+		PartnerId:    p.PartnerId,
+		PartnerCode:  p.PartnerCode,
+		Ip:           p.Ip,
+		PartnerName:  p.PartnerName,
+		DiffHour:     p.SessionContext.DiffHour,
+		DeviceId:     p.DeviceId,
+		AccountType:  p.AccountType,
+	}
+}
+
+// principalServerStream struct override context of stream with the one carrying principal
+type principalServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"reflect"
 )
 
@@ -61,24 +64,21 @@ func printStruct(showDetails bool, inStruct reflect.Value) {
 	fmt.Println()
 }
 
+// PrintRequest function log request of methodName at debug level, sensitive params are redacted
+// and params longer than LogMaxBodySize are omitted
 func PrintRequest(methodName string, req interface{}) {
+	PrintRequestContext(context.Background(), methodName, req)
+}
 
-	fmt.Println(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>")
-	fmt.Println("Request: %v", methodName)
-	if len(fmt.Sprintf("%#v", req)) < 1024 {
-
-		fmt.Println("Param: %v", req)
+// PrintRequestContext function same as PrintRequest with request attributes of ctx
+func PrintRequestContext(ctx context.Context, methodName string, req interface{}) {
+	if !Logger().Enabled(ctx, slog.LevelDebug) {
+		return
 	}
-
-	fmt.Println(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>")
-
-	// skylog.Infof(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>")
-	// skylog.Infof("Request: %v", methodName)
-
-	// if len(fmt.Sprintf("%#v", req)) < 1024 {
-	// 	skylog.Infof("Param: %v", req)
-	// }
-
-	// skylog.Infof(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>")
-
+	attrs := []any{"request", methodName}
+	param := RedactLogValue(req)
+	if data, err := json.Marshal(param); err == nil && len(data) < LogMaxBodySize {
+		attrs = append(attrs, "param", param)
+	}
+	Logger().DebugContext(ctx, "Request", attrs...)
 }
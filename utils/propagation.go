@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// hopByHopHeaders are meaningful only for a single connection (RFC 7230 section 6.1)
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"proxy-connection":    true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// PropagationPolicy struct decides which headers / metadata cross a REST or gRPC hop.
+// Keys are case-insensitive, a key ending with "*" matches by prefix
+type PropagationPolicy struct {
+	// Allow list, empty allows every key
+	Allow []string
+	// Deny list, checked after Allow
+	Deny []string
+	// Rename maps source key to destination key
+	Rename map[string]string
+	// StripHopByHop drops Connection, Keep-Alive, Transfer-Encoding... and keys listed in Connection
+	StripHopByHop bool
+	// StripPseudo drops HTTP/2 pseudo-headers like ":authority"
+	StripPseudo bool
+}
+
+// DefaultPropagationPolicy function return policy forwarding auth, api key, client info, forwarding, request id and locale headers
+func DefaultPropagationPolicy() *PropagationPolicy {
+	return &PropagationPolicy{
+		Allow: []string{
+			"authorization",
+			ApiKeyHeader,
+			"user-agent",
+			"origin",
+			"grpcgateway-origin",
+			"x-forwarded-*",
+			"x-real-ip",
+			"x-request-id",
+			"x-correlation-id",
+			"accept-language",
+			"locale",
+			"x-locale",
+			SessionContextHeader,
+		},
+		StripHopByHop: true,
+		StripPseudo:   true,
+	}
+}
+
+// PropagationPolicy global instance, used by SendRawFromRequest, SendMultiPartForm, AuthInterceptor, NewServeMux and MakePropagatedContext
+var (
+	GlobalPropagationPolicy = DefaultPropagationPolicy()
+)
+
+// outboundPropagationPolicy function return GlobalPropagationPolicy for calls to other services,
+// the api key identifies the caller of this service and is not forwarded
+func outboundPropagationPolicy() *PropagationPolicy {
+	return GlobalPropagationPolicy.Without(ApiKeyHeader)
+}
+
+func matchPropagationKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if pattern == key {
+			return true
+		}
+	}
+	return false
+}
+
+// With function return copy of policy which also allows keys
+func (p *PropagationPolicy) With(keys ...string) *PropagationPolicy {
+	if p == nil {
+		return nil
+	}
+	cloned := *p
+	if len(p.Allow) > 0 {
+		cloned.Allow = append(append([]string{}, p.Allow...), keys...)
+	}
+	return &cloned
+}
+
+// Without function return copy of policy which also denies keys
+func (p *PropagationPolicy) Without(keys ...string) *PropagationPolicy {
+	if p == nil {
+		return &PropagationPolicy{Deny: keys}
+	}
+	cloned := *p
+	cloned.Deny = append(append([]string{}, p.Deny...), keys...)
+	return &cloned
+}
+
+// Key function return destination key (lower case) of key, false when key must not cross the hop
+func (p *PropagationPolicy) Key(key string) (string, bool) {
+	key = strings.ToLower(key)
+	if p == nil {
+		return key, true
+	}
+	if p.StripPseudo && strings.HasPrefix(key, ":") {
+		return "", false
+	}
+	if p.StripHopByHop && hopByHopHeaders[key] {
+		return "", false
+	}
+	if len(p.Allow) > 0 && !matchPropagationKey(p.Allow, key) {
+		return "", false
+	}
+	if matchPropagationKey(p.Deny, key) {
+		return "", false
+	}
+	for from, to := range p.Rename {
+		if strings.ToLower(from) == key {
+			return strings.ToLower(to), true
+		}
+	}
+	return key, true
+}
+
+// connectionKeys function return keys listed in Connection header, they are hop-by-hop too
+func (p *PropagationPolicy) connectionKeys(values []string) map[string]bool {
+	keys := map[string]bool{}
+	if p == nil || !p.StripHopByHop {
+		return keys
+	}
+	for _, value := range values {
+		for _, key := range strings.Split(value, ",") {
+			keys[strings.ToLower(strings.TrimSpace(key))] = true
+		}
+	}
+	return keys
+}
+
+// HeaderToMetadata function copy allowed http headers to gRPC metadata
+func (p *PropagationPolicy) HeaderToMetadata(header http.Header, md metadata.MD) metadata.MD {
+	if md == nil {
+		md = metadata.MD{}
+	}
+	skip := p.connectionKeys(header.Values("Connection"))
+	for key, values := range header {
+		if skip[strings.ToLower(key)] {
+			continue
+		}
+		if destKey, ok := p.Key(key); ok {
+			md.Append(destKey, values...)
+		}
+	}
+	return md
+}
+
+// MetadataToHeader function copy allowed gRPC metadata to http headers
+func (p *PropagationPolicy) MetadataToHeader(md metadata.MD, header http.Header) http.Header {
+	if header == nil {
+		header = http.Header{}
+	}
+	skip := p.connectionKeys(md.Get("connection"))
+	for key, values := range md {
+		if skip[key] || strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		if destKey, ok := p.Key(key); ok {
+			for _, value := range values {
+				header.Add(destKey, value)
+			}
+		}
+	}
+	return header
+}
+
+// HeaderToHeader function copy allowed http headers from an incoming request to an outgoing one
+func (p *PropagationPolicy) HeaderToHeader(src http.Header, dest http.Header) http.Header {
+	if dest == nil {
+		dest = http.Header{}
+	}
+	skip := p.connectionKeys(src.Values("Connection"))
+	for key, values := range src {
+		if skip[strings.ToLower(key)] {
+			continue
+		}
+		if destKey, ok := p.Key(key); ok {
+			for _, value := range values {
+				dest.Add(destKey, value)
+			}
+		}
+	}
+	return dest
+}
+
+// MetadataToMetadata function copy allowed incoming metadata to outgoing metadata
+func (p *PropagationPolicy) MetadataToMetadata(src metadata.MD, dest metadata.MD) metadata.MD {
+	if dest == nil {
+		dest = metadata.MD{}
+	}
+	skip := p.connectionKeys(src.Get("connection"))
+	for key, values := range src {
+		if skip[key] {
+			continue
+		}
+		if destKey, ok := p.Key(key); ok {
+			dest.Append(destKey, values...)
+		}
+	}
+	return dest
+}
@@ -1,22 +1,18 @@
 package utils
 
 import (
-	"fmt"
 	"reflect"
 	"strings"
 
 	"github.com/lingdor/stackerror"
 	"github.com/stoewer/go-strcase"
-	// "suntech.com.vn/skylib/skylog.git/skylog"
 )
 
 func recoverSetReflectValue(structName, fieldName string, field reflect.Value, value interface{}) {
 	if r := recover(); r != nil {
-		fmt.Println("recovered from ", r)
-		// skylog.Errorf("Field: %v of (%v) - Struct type: %v - DB type: %v", fieldName, structName, field.Type(), reflect.TypeOf(value))
-		fmt.Println("Field: %v of (%v) - Struct type: %v - DB type: %v", fieldName, structName, field.Type(), reflect.TypeOf(value))
 		e := stackerror.New("=======stackerror=======")
-		fmt.Println(e.Error())
+		Logger().Error("SetReflectValue recovered", "recovered", r, "field", fieldName, "struct", structName,
+			"structType", field.Type(), "dbType", reflect.TypeOf(value), "stack", e.Error())
 	}
 }
 
@@ -25,8 +21,7 @@ func SetReflectValue(structName, fieldName string, field reflect.Value, value in
 	defer recoverSetReflectValue(structName, fieldName, field, value)
 
 	if !field.IsValid() {
-		// skylog.Infof("Field [%v] does not exist", field)
-		fmt.Println("Field [%v] does not exist", field)
+		Logger().Debug("SetReflectValue field does not exist", "field", fieldName, "struct", structName)
 		return
 	}
 
@@ -69,8 +64,7 @@ func SetReflectValue(structName, fieldName string, field reflect.Value, value in
 // SetReflectField function
 func SetReflectField(st, field reflect.Value, fieldName string, value interface{}) {
 	if !field.IsValid() {
-		// skylog.Infof("Field [%v] does not exist on struct [%v]", fieldName, st.Type().Name())
-		fmt.Println("Field [%v] does not exist on struct [%v]", fieldName, st.Type().Name())
+		Logger().Debug("SetReflectField field does not exist", "field", fieldName, "struct", st.Type().Name())
 		return
 	}
 	SetReflectValue(GetStructNameFromValue(st), fieldName, field, value)
@@ -113,8 +107,7 @@ func ResetSliceOrStruct(source interface{}) {
 		v.Elem().Set(reflect.MakeSlice(v.Type().Elem(), 0, v.Elem().Cap()))
 	} else {
 		if reflect.ValueOf(source).Kind() != reflect.Ptr {
-			// skylog.Error("Require a pointer parameter")
-			fmt.Println("Require a pointer parameter")
+			Logger().Error("ResetSliceOrStruct require a pointer parameter", "type", reflect.TypeOf(source))
 			return
 		}
 		p := reflect.ValueOf(source).Elem()
@@ -0,0 +1,371 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	//RefreshTokenInvalidError error
+	RefreshTokenInvalidError = status.Error(codes.Unauthenticated, "SYS.MSG.REFRESH_TOKEN_INVALID_ERROR")
+	//RefreshTokenExpiredError error
+	RefreshTokenExpiredError = status.Error(codes.Unauthenticated, "SYS.MSG.REFRESH_TOKEN_EXPIRED_ERROR")
+	//RefreshTokenReusedError error, the whole token family is revoked
+	RefreshTokenReusedError = status.Error(codes.Unauthenticated, "SYS.MSG.REFRESH_TOKEN_REUSED_ERROR")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+const (
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	refreshTokenType       = "refresh"
+	// refreshStoreSweepInterval is the minimum time between two sweeps of expired tokens
+	refreshStoreSweepInterval = time.Minute
+)
+
+// RefreshToken struct is the stored state of a refresh token
+type RefreshToken struct {
+	Id       string // jti, or HashRefreshToken of opaque token
+	FamilyId string // shared by every token rotated from the same login
+	Account  Account
+	IssuedAt time.Time
+	// ExpiresAt is kept from the first token of family, rotation does not extend the session
+	ExpiresAt  time.Time
+	ReplacedBy string // jti of the token it was rotated to, empty while unused
+	Revoked    bool
+}
+
+// RefreshStore interface persist refresh tokens
+type RefreshStore interface {
+	Save(ctx context.Context, token RefreshToken) error
+	// Get function return ErrRefreshTokenNotFound for unknown id
+	Get(ctx context.Context, id string) (RefreshToken, error)
+	// MarkUsed function set ReplacedBy atomically, return false when token was already used or revoked
+	MarkUsed(ctx context.Context, id, replacedBy string) (bool, error)
+	RevokeFamily(ctx context.Context, familyId string) error
+	RevokeUser(ctx context.Context, userId int64) error
+}
+
+// MemoryRefreshStore struct is an in-memory RefreshStore, expired tokens are swept by Save at most once a minute
+type MemoryRefreshStore struct {
+	mu        sync.Mutex
+	tokens    map[string]RefreshToken
+	lastSweep time.Time
+}
+
+// NewMemoryRefreshStore function create new MemoryRefreshStore
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens: map[string]RefreshToken{},
+	}
+}
+
+func (s *MemoryRefreshStore) Save(ctx context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= refreshStoreSweepInterval {
+		s.lastSweep = now
+		for id, item := range s.tokens {
+			if now.After(item.ExpiresAt) {
+				delete(s.tokens, id)
+			}
+		}
+	}
+	s.tokens[token.Id] = token
+	return nil
+}
+
+func (s *MemoryRefreshStore) Get(ctx context.Context, id string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryRefreshStore) MarkUsed(ctx context.Context, id, replacedBy string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, ErrRefreshTokenNotFound
+	}
+	if token.ReplacedBy != "" || token.Revoked {
+		return false, nil
+	}
+	token.ReplacedBy = replacedBy
+	s.tokens[id] = token
+	return true, nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(ctx context.Context, familyId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.FamilyId == familyId {
+			token.Revoked = true
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeUser(ctx context.Context, userId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.Account.Id == userId {
+			token.Revoked = true
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+// RefreshTokenManager struct issue and rotate refresh tokens.
+// With JwtManager tokens are JWT carrying jti, otherwise tokens are opaque random strings stored by HashRefreshToken
+type RefreshTokenManager struct {
+	Store      RefreshStore
+	JwtManager *JwtManager
+	TTL        time.Duration
+	// Legacy verifies refresh tokens of JwtManager.Generate which are not in Store, for migration.
+	// Such a token is exchanged once by Rotate for a stored token, nil rejects them
+	Legacy *JwtManager
+}
+
+// NewRefreshTokenManager function create new RefreshTokenManager, jwtManager nil for opaque tokens
+func NewRefreshTokenManager(store RefreshStore, jwtManager *JwtManager, ttl time.Duration) *RefreshTokenManager {
+	if ttl <= 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+	return &RefreshTokenManager{
+		Store:      store,
+		JwtManager: jwtManager,
+		TTL:        ttl,
+	}
+}
+
+// NewTokenId function return random url safe id
+func NewTokenId() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashRefreshToken function return store id of opaque refresh token
+func HashRefreshToken(token string) string {
+	return Sha256(token)
+}
+
+// newId function return store id of a new token and, for opaque tokens, the token itself
+func (m *RefreshTokenManager) newId() (string, string) {
+	if m.JwtManager != nil {
+		return NewTokenId(), ""
+	}
+	secret := NewTokenId()
+	return HashRefreshToken(secret), secret
+}
+
+// Issue function create refresh token of a new login
+func (m *RefreshTokenManager) Issue(ctx context.Context, account Account) (string, error) {
+	now := time.Now()
+	id, secret := m.newId()
+	token := RefreshToken{
+		Id:        id,
+		FamilyId:  NewTokenId(),
+		Account:   account,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.TTL),
+	}
+	return m.save(ctx, token, secret)
+}
+
+// Rotate function exchange refresh token for a new one, reuse of a rotated token revokes its whole family
+func (m *RefreshTokenManager) Rotate(ctx context.Context, tokenString string) (string, RefreshToken, error) {
+	current, err := m.lookup(ctx, tokenString)
+	if errors.Is(err, RefreshTokenInvalidError) && m.Legacy != nil {
+		current, err = m.adoptLegacy(ctx, tokenString)
+	}
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+
+	id, secret := m.newId()
+	next := RefreshToken{
+		Id:        id,
+		FamilyId:  current.FamilyId,
+		Account:   current.Account,
+		IssuedAt:  time.Now(),
+		ExpiresAt: current.ExpiresAt,
+	}
+	// save the new token first, a failed save keeps the current token usable
+	newToken, err := m.save(ctx, next, secret)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+	ok, err := m.Store.MarkUsed(ctx, current.Id, next.Id)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+	if !ok {
+		// lost a race with another rotation of the same token, the family includes next
+		if err := m.Store.RevokeFamily(ctx, current.FamilyId); err != nil {
+			return "", RefreshToken{}, err
+		}
+		return "", RefreshToken{}, RefreshTokenReusedError
+	}
+	return newToken, next, nil
+}
+
+// adoptLegacy function save a refresh token of JwtManager.Generate verified by Legacy as the first token of a new family,
+// stored by HashRefreshToken so it is rotated only once
+func (m *RefreshTokenManager) adoptLegacy(ctx context.Context, tokenString string) (RefreshToken, error) {
+	claims, err := m.Legacy.VerifyRefresh(tokenString)
+	if err != nil {
+		if errors.Is(err, ErrJwtExpired) {
+			return RefreshToken{}, RefreshTokenExpiredError
+		}
+		return RefreshToken{}, RefreshTokenInvalidError
+	}
+	accountClaims, err := DecodeAccountClaims(*claims)
+	if err != nil {
+		return RefreshToken{}, RefreshTokenInvalidError
+	}
+	id := HashRefreshToken(tokenString)
+	if token, err := m.Store.Get(ctx, id); err == nil {
+		// adopted before, reuse is detected like any stored token
+		return m.check(ctx, token)
+	} else if !errors.Is(err, ErrRefreshTokenNotFound) {
+		return RefreshToken{}, err
+	}
+
+	now := time.Now()
+	token := RefreshToken{
+		Id:        id,
+		FamilyId:  NewTokenId(),
+		Account:   accountClaims.Account(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.TTL),
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil && exp.Time.Before(token.ExpiresAt) {
+		token.ExpiresAt = exp.Time
+	}
+	if err := m.Store.Save(ctx, token); err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// Revoke function revoke family of refresh token, e.g. on logout
+func (m *RefreshTokenManager) Revoke(ctx context.Context, tokenString string) error {
+	id, err := m.tokenId(tokenString)
+	if err != nil {
+		return err
+	}
+	token, err := m.Store.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return RefreshTokenInvalidError
+		}
+		return err
+	}
+	return m.Store.RevokeFamily(ctx, token.FamilyId)
+}
+
+// RevokeUser function revoke every refresh token of user, e.g. on password change
+func (m *RefreshTokenManager) RevokeUser(ctx context.Context, userId int64) error {
+	return m.Store.RevokeUser(ctx, userId)
+}
+
+// lookup function return stored token, detecting reuse and expiry
+func (m *RefreshTokenManager) lookup(ctx context.Context, tokenString string) (RefreshToken, error) {
+	id, err := m.tokenId(tokenString)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	token, err := m.Store.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return RefreshToken{}, RefreshTokenInvalidError
+		}
+		return RefreshToken{}, err
+	}
+	return m.check(ctx, token)
+}
+
+// check function return token when it is usable, revoke its family when it was already rotated
+func (m *RefreshTokenManager) check(ctx context.Context, token RefreshToken) (RefreshToken, error) {
+	if token.Revoked {
+		return RefreshToken{}, RefreshTokenInvalidError
+	}
+	if token.ReplacedBy != "" {
+		// a rotated token is presented again: it was stolen or replayed
+		if err := m.Store.RevokeFamily(ctx, token.FamilyId); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, RefreshTokenReusedError
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return RefreshToken{}, RefreshTokenExpiredError
+	}
+	return token, nil
+}
+
+// save function store token and return its string form, secret is the opaque token
+func (m *RefreshTokenManager) save(ctx context.Context, token RefreshToken, secret string) (string, error) {
+	if err := m.Store.Save(ctx, token); err != nil {
+		return "", err
+	}
+	if m.JwtManager == nil {
+		return secret, nil
+	}
+	claims := jwt.MapClaims{
+		"jti": token.Id,
+		"fid": token.FamilyId,
+		"sub": fmt.Sprintf("%v", token.Account.Id),
+		"typ": refreshTokenType,
+		"iat": token.IssuedAt.Unix(),
+		"exp": token.ExpiresAt.Unix(),
+	}
+	if m.JwtManager.Validation.Issuer != "" {
+		claims["iss"] = m.JwtManager.Validation.Issuer
+	}
+	if len(m.JwtManager.Validation.Audiences) > 0 {
+		claims["aud"] = m.JwtManager.Validation.Audiences[0]
+	}
+	return m.JwtManager.signToken(claims)
+}
+
+// tokenId function return store id of token string
+func (m *RefreshTokenManager) tokenId(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", RefreshTokenInvalidError
+	}
+	if m.JwtManager == nil {
+		return HashRefreshToken(tokenString), nil
+	}
+	claims, err := m.JwtManager.parse(tokenString)
+	if err != nil {
+		if errors.Is(err, ErrJwtExpired) {
+			return "", RefreshTokenExpiredError
+		}
+		return "", RefreshTokenInvalidError
+	}
+	id, _ := claims["jti"].(string)
+	if typ, _ := claims["typ"].(string); typ != refreshTokenType || id == "" {
+		return "", RefreshTokenInvalidError
+	}
+	return id, nil
+}
@@ -2,18 +2,429 @@ package utils
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
+// MakeRemoteConn function dial new plaintext connection to remoteHost, caller must close it.
+//
+// Deprecated: it dials a connection per call without TLS, use DefaultRemoteConnFactory.Conn which is TLS by
+// default and returns a shared connection (do not close it)
 func MakeRemoteConn(remoteHost string) (*grpc.ClientConn, error) {
-	return grpc.Dial(remoteHost, grpc.WithInsecure(), grpc.WithUnaryInterceptor(GlobalAuthInterceptor.ClientUnary()), grpc.WithStreamInterceptor(GlobalAuthInterceptor.ClientStream()))
+	return DefaultRemoteConnFactory.Dial(remoteHost, WithInsecureTransport())
 }
 
+// MakeRemoteConnWithPackage function dial new plaintext connection to remoteHost rewriting package test to replaceWith,
+// caller must close it.
+//
+// Deprecated: it dials a connection per call without TLS, use NewRemoteConnFactory(WithRoutes(...)).Conn
 func MakeRemoteConnWithPackage(remoteHost, test, replaceWith string) (*grpc.ClientConn, error) {
-	return grpc.Dial(remoteHost, grpc.WithInsecure(), grpc.WithUnaryInterceptor(GlobalAuthInterceptor.ClientUnaryWithPackage(test, replaceWith)), grpc.WithStreamInterceptor(GlobalAuthInterceptor.ClientStreamWithPackage(test, replaceWith)))
+	return DefaultRemoteConnFactory.Dial(remoteHost, WithInsecureTransport(), WithPackageRewrite(test, replaceWith))
 }
 
+// MakeRemoteConnContextWithPackage function same as MakeRemoteConnWithPackage, fail when ctx is done
+//
+// Deprecated: it dials a connection per call without TLS, use NewRemoteConnFactory(WithRoutes(...)).Conn
 func MakeRemoteConnContextWithPackage(ctx context.Context, remoteHost, test, replaceWith string) (*grpc.ClientConn, error) {
-	return grpc.DialContext(ctx, remoteHost, grpc.WithInsecure(), grpc.WithUnaryInterceptor(GlobalAuthInterceptor.ClientUnaryWithPackage(test, replaceWith)), grpc.WithStreamInterceptor(GlobalAuthInterceptor.ClientStreamWithPackage(test, replaceWith)))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return MakeRemoteConnWithPackage(remoteHost, test, replaceWith)
+}
+
+// GrpcRetryPolicy struct is the retry policy of gRPC service config
+type GrpcRetryPolicy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableStatusCodes, default Unavailable
+	RetryableStatusCodes []codes.Code
+}
+
+// DefaultGrpcRetryPolicy function return policy retrying Unavailable 3 times
+func DefaultGrpcRetryPolicy() GrpcRetryPolicy {
+	return GrpcRetryPolicy{
+		MaxAttempts:          3,
+		InitialBackoff:       100 * time.Millisecond,
+		MaxBackoff:           2 * time.Second,
+		BackoffMultiplier:    2,
+		RetryableStatusCodes: []codes.Code{codes.Unavailable},
+	}
+}
+
+// RemoteConnOption function configure connections of RemoteConnFactory
+type RemoteConnOption func(*remoteConnConfig)
+
+type remoteConnConfig struct {
+	transport   credentials.TransportCredentials
+	err         error
+	keepalive   *keepalive.ClientParameters
+	retry       *GrpcRetryPolicy
+	timeout     time.Duration
+	routes      *RouteTable
+	rewriteFrom string
+	rewriteTo   string
+	noAuth      bool
+	tokenSource TokenSource
+	unary       []grpc.UnaryClientInterceptor
+	stream      []grpc.StreamClientInterceptor
+	dialOptions []grpc.DialOption
+	breakers    *CircuitBreakerRegistry
+	breakersSet bool
+}
+
+// WithInsecureTransport function use plaintext connections instead of the default TLS, only for trusted networks
+func WithInsecureTransport() RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.transport = insecure.NewCredentials()
+	}
+}
+
+// WithTLSConfig function use TLS with config
+func WithTLSConfig(config *tls.Config) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.transport = credentials.NewTLS(config)
+	}
+}
+
+// WithTLS function use TLS verifying server with CA bundle file, empty caFile uses system roots.
+// serverName overrides the name checked in server certificate, empty to use target host
+func WithTLS(caFile, serverName string) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		config, err := newClientTLSConfig(caFile, serverName)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.transport = credentials.NewTLS(config)
+	}
+}
+
+// WithMutualTLS function use TLS with client certificate loaded from certFile / keyFile
+func WithMutualTLS(caFile, certFile, keyFile, serverName string) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		config, err := newClientTLSConfig(caFile, serverName)
+		if err != nil {
+			c.err = err
+			return
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			c.err = fmt.Errorf("load client certificate: %w", err)
+			return
+		}
+		config.Certificates = []tls.Certificate{cert}
+		c.transport = credentials.NewTLS(config)
+	}
+}
+
+func newClientTLSConfig(caFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if caFile == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("load CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %v has no certificate", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// WithKeepalive function send pings every interval, the connection is closed when a ping is not acked within timeout
+func WithKeepalive(interval, timeout time.Duration, permitWithoutStream bool) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.keepalive = &keepalive.ClientParameters{
+			Time:                interval,
+			Timeout:             timeout,
+			PermitWithoutStream: permitWithoutStream,
+		}
+	}
+}
+
+// WithRetryPolicy function set retry policy of default service config
+func WithRetryPolicy(policy GrpcRetryPolicy) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.retry = &policy
+	}
+}
+
+// WithCallTimeout function set default deadline of calls without one
+func WithCallTimeout(timeout time.Duration) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithRoutes function route calls of the connection by table, see RouteTable
+func WithRoutes(table *RouteTable) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.routes = table
+	}
+}
+
+// WithPackageRewrite function replace every occurrence of test in called methods with replaceWith,
+// before routes of WithRoutes
+//
+// Deprecated: use WithRoutes
+func WithPackageRewrite(test, replaceWith string) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.rewriteFrom, c.rewriteTo = test, replaceWith
+	}
+}
+
+// WithoutAuth function do not attach GlobalAuthInterceptor
+func WithoutAuth() RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.noAuth = true
+	}
+}
+
+// WithTokenSource function attach tokens of source instead of the token source of GlobalAuthInterceptor,
+// e.g. FallbackTokenSource(NewServiceTokenSource(...)) for background jobs
+func WithTokenSource(source TokenSource) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.tokenSource = source
+	}
+}
+
+// WithCircuitBreakers function set registry of circuit breakers, nil to disable
+func WithCircuitBreakers(registry *CircuitBreakerRegistry) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.breakers = registry
+		c.breakersSet = true
+	}
+}
+
+// WithInterceptors function append client interceptors, after auth and circuit breaker
+func WithInterceptors(unary []grpc.UnaryClientInterceptor, stream []grpc.StreamClientInterceptor) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.unary = append(c.unary, unary...)
+		c.stream = append(c.stream, stream...)
+	}
+}
+
+// WithDialOptions function append raw dial options
+func WithDialOptions(opts ...grpc.DialOption) RemoteConnOption {
+	return func(c *remoteConnConfig) {
+		c.dialOptions = append(c.dialOptions, opts...)
+	}
+}
+
+// grpcServiceConfig struct is the JSON service config of gRPC
+type grpcServiceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	MethodConfig        []grpcMethodConfig    `json:"methodConfig,omitempty"`
+}
+
+type grpcMethodConfig struct {
+	Name        []struct{}           `json:"name"`
+	Timeout     string               `json:"timeout,omitempty"`
+	RetryPolicy *grpcRetryPolicyJSON `json:"retryPolicy,omitempty"`
+}
+
+type grpcRetryPolicyJSON struct {
+	MaxAttempts          int          `json:"maxAttempts"`
+	InitialBackoff       string       `json:"initialBackoff"`
+	MaxBackoff           string       `json:"maxBackoff"`
+	BackoffMultiplier    float64      `json:"backoffMultiplier"`
+	RetryableStatusCodes []codes.Code `json:"retryableStatusCodes"`
+}
+
+func grpcDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+// serviceConfig function return JSON service config, empty when nothing is configured
+func (c *remoteConnConfig) serviceConfig(roundRobin bool) string {
+	var config grpcServiceConfig
+	if roundRobin {
+		config.LoadBalancingConfig = []map[string]struct{}{{"round_robin": {}}}
+	}
+	if c.retry != nil || c.timeout > 0 {
+		// one empty name applies the config to every method
+		methodConfig := grpcMethodConfig{Name: []struct{}{{}}}
+		if c.timeout > 0 {
+			methodConfig.Timeout = grpcDuration(c.timeout)
+		}
+		if c.retry != nil {
+			retryCodes := c.retry.RetryableStatusCodes
+			if len(retryCodes) == 0 {
+				retryCodes = []codes.Code{codes.Unavailable}
+			}
+			multiplier := c.retry.BackoffMultiplier
+			if multiplier <= 0 {
+				multiplier = 2
+			}
+			methodConfig.RetryPolicy = &grpcRetryPolicyJSON{
+				MaxAttempts:          c.retry.MaxAttempts,
+				InitialBackoff:       grpcDuration(c.retry.InitialBackoff),
+				MaxBackoff:           grpcDuration(c.retry.MaxBackoff),
+				BackoffMultiplier:    multiplier,
+				RetryableStatusCodes: retryCodes,
+			}
+		}
+		config.MethodConfig = []grpcMethodConfig{methodConfig}
+	}
+	if len(config.LoadBalancingConfig) == 0 && len(config.MethodConfig) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(config)
+	return string(data)
+}
+
+// RemoteConnFactory struct create gRPC connections with shared options and caches them by target
+type RemoteConnFactory struct {
+	options []RemoteConnOption
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// RemoteConnFactory global instance, TLS with system roots; the deprecated MakeRemoteConn helpers dial with
+// its options and plaintext transport.
+// Plaintext services must opt in: DefaultRemoteConnFactory = NewRemoteConnFactory(WithInsecureTransport())
+var (
+	DefaultRemoteConnFactory = NewRemoteConnFactory()
+)
+
+// NewRemoteConnFactory function create new RemoteConnFactory, connections use TLS verified with system roots
+// unless a transport option (WithTLS, WithMutualTLS, WithTLSConfig or WithInsecureTransport) is given
+func NewRemoteConnFactory(options ...RemoteConnOption) *RemoteConnFactory {
+	return &RemoteConnFactory{
+		options: options,
+		conns:   map[string]*grpc.ClientConn{},
+	}
+}
+
+// Dial function return new connection to remoteHost, caller must close it.
+// Unlike grpc.NewClient, whose default scheme is dns, a target without scheme ("host:port") is
+// dialed with passthrough like grpc.Dial did; use "dns:///host:port" for DNS load balancing
+func (f *RemoteConnFactory) Dial(remoteHost string, options ...RemoteConnOption) (*grpc.ClientConn, error) {
+	config := &remoteConnConfig{}
+	for _, option := range append(append([]RemoteConnOption{}, f.options...), options...) {
+		option(config)
+	}
+	if config.err != nil {
+		return nil, config.err
+	}
+	if config.transport == nil {
+		tlsConfig, _ := newClientTLSConfig("", "")
+		config.transport = credentials.NewTLS(tlsConfig)
+	}
+
+	target, builder := serviceGrpcTarget(remoteHost)
+	if builder == nil && !strings.Contains(target, "://") {
+		target = "passthrough:///" + target
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(config.transport)}
+	if builder != nil {
+		opts = append(opts, grpc.WithResolvers(builder))
+	}
+	if serviceConfig := config.serviceConfig(builder != nil); serviceConfig != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig))
+	}
+	if config.keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(*config.keepalive))
+	}
+
+	unary, stream := config.interceptorChain()
+	opts = append(opts, grpc.WithChainUnaryInterceptor(unary...), grpc.WithChainStreamInterceptor(stream...))
+	opts = append(opts, config.dialOptions...)
+	return grpc.NewClient(target, opts...)
+}
+
+// interceptorChain function return package rewrite, routing, auth, circuit breaker then custom interceptors
+func (c *remoteConnConfig) interceptorChain() ([]grpc.UnaryClientInterceptor, []grpc.StreamClientInterceptor) {
+	var unary []grpc.UnaryClientInterceptor
+	var stream []grpc.StreamClientInterceptor
+	if c.rewriteFrom != "" {
+		unary = append(unary, legacyRewriteClientUnary(c.rewriteFrom, c.rewriteTo))
+		stream = append(stream, legacyRewriteClientStream(c.rewriteFrom, c.rewriteTo))
+	}
+	if c.routes != nil {
+		unary = append(unary, RouteClientUnary(c.routes))
+		stream = append(stream, RouteClientStream(c.routes))
+	}
+	if !c.noAuth {
+		auth := c.authInterceptor()
+		unary = append(unary, auth.ClientUnary())
+		stream = append(stream, auth.ClientStream())
+	}
+	breakers := GlobalCircuitBreakers
+	if c.breakersSet {
+		breakers = c.breakers
+	}
+	if breakers != nil {
+		unary = append(unary, CircuitBreakerClientUnary(breakers))
+		stream = append(stream, CircuitBreakerClientStream(breakers))
+	}
+	return append(unary, c.unary...), append(stream, c.stream...)
+}
+
+// authInterceptor function return interceptor attaching tokens, GlobalAuthInterceptor unless a token source is set
+func (c *remoteConnConfig) authInterceptor() *AuthInterceptor {
+	if c.tokenSource != nil {
+		return &AuthInterceptor{tokenSource: c.tokenSource}
+	}
+	if GlobalAuthInterceptor == nil {
+		return &AuthInterceptor{}
+	}
+	return GlobalAuthInterceptor
+}
+
+// Conn function return cached connection to remoteHost, created with factory options on first use.
+// The cache is keyed by remoteHost only, so every caller shares one connection with the factory options;
+// use Dial or another factory for per-call options. Cached connections are shared, do not close them, use Close of factory
+func (f *RemoteConnFactory) Conn(remoteHost string) (*grpc.ClientConn, error) {
+	return f.conn(remoteHost, remoteHost)
+}
+
+// conn function return connection cached by key, dialed with factory options and options on first use
+func (f *RemoteConnFactory) conn(key, remoteHost string, options ...RemoteConnOption) (*grpc.ClientConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if conn, ok := f.conns[key]; ok && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+	conn, err := f.Dial(remoteHost, options...)
+	if err != nil {
+		return nil, err
+	}
+	f.conns[key] = conn
+	return conn, nil
+}
+
+// Close function close all cached connections
+func (f *RemoteConnFactory) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for key, conn := range f.conns {
+		errs = append(errs, conn.Close())
+		delete(f.conns, key)
+	}
+	return errors.Join(errs...)
 }
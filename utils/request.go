@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,20 +10,27 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	// "suntech.com.vn/skylib/skylog.git/skylog"
 )
 
 const DefaultRequestTimeout = 15 * time.Second
 
+// requestTransport is shared by RequestUtil without Transport, so connections are reused across requests
+var requestTransport = http.DefaultTransport.(*http.Transport).Clone()
+
 type RequestUtil struct {
 	Method             string
 	Url                string
 	Data               map[string]interface{}
+	Body               interface{} // typed json body (struct, slice), used instead of Data when not nil
 	Timeout            time.Duration
 	Authorization      string
 	Headers            map[string]string
 	Transport          *http.Transport
-	InsecureSkipVerify int // -1: SkipVerify; 0: Keep default; 1: NotSkipVerify
+	InsecureSkipVerify int          // -1: SkipVerify; 0: Keep default; 1: NotSkipVerify
+	Retry              *RetryPolicy // nil: DefaultRetryPolicy
+
+	// httpClient is built by ToHttpClient on first use
+	httpClient *HttpClient
 }
 
 func NewRequest(method, url string, data map[string]interface{}) *RequestUtil {
@@ -35,7 +43,6 @@ func NewRequest(method, url string, data map[string]interface{}) *RequestUtil {
 	r.Headers = map[string]string{
 		"Content-Type": "application/json",
 	}
-	r.Transport = http.DefaultTransport.(*http.Transport).Clone()
 	r.InsecureSkipVerify = 0
 	return &r
 }
@@ -116,8 +123,7 @@ func (r *RequestUtil) ToRequest(body io.Reader) (*http.Request, error) {
 	// create request
 	req, err := http.NewRequest(r.Method, r.Url, body)
 	if err != nil {
-		// skylog.Errorf("SendRawFromRequest create request error: %v", err)
-		fmt.Println("SendRawFromRequest create request error: %v", err)
+		Logger().Error("RequestUtil create request error", "method", r.Method, "url", r.Url, "error", err)
 		return nil, err
 	}
 
@@ -138,27 +144,61 @@ func (r *RequestUtil) ToRequest(body io.Reader) (*http.Request, error) {
 	return req, nil
 }
 
+// ToClient function return http client of request options, nil Transport uses a shared transport
 func (r *RequestUtil) ToClient() *http.Client {
+	if r.Transport == nil && r.InsecureSkipVerify == 0 {
+		return &http.Client{
+			Transport: NewMiddlewareTransport(requestTransport),
+			Timeout:   r.Timeout,
+		}
+	}
 	// create http client
+	if r.Transport == nil {
+		r.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
 	if r.InsecureSkipVerify < 0 {
 		r.Transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	} else if r.InsecureSkipVerify > 0 {
 		r.Transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
 	}
 	return &http.Client{
-		Transport: r.Transport,
+		Transport: NewMiddlewareTransport(r.Transport),
 		Timeout:   r.Timeout,
 	}
 }
 
+// ToHttpClient function return HttpClient of request options, built on first call and reused by
+// every send of r; options changed afterwards are ignored
+func (r *RequestUtil) ToHttpClient() *HttpClient {
+	if r.httpClient != nil {
+		return r.httpClient
+	}
+	retry := DefaultRetryPolicy()
+	if r.Retry != nil {
+		retry = *r.Retry
+	}
+	client := NewHttpClient(r.ToClient(), retry)
+	client.Breakers = GlobalCircuitBreakers
+	r.httpClient = client
+	return client
+}
+
 func (r *RequestUtil) SendRaw() (*http.Response, error) {
+	return r.SendRawWithContext(context.Background())
+}
+
+// SendRawWithContext function send request bound to ctx, with retry
+func (r *RequestUtil) SendRawWithContext(ctx context.Context) (*http.Response, error) {
 	// data
 	var data io.Reader = nil
 	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
-		bodyData, err := json.Marshal(r.Data)
+		var body interface{} = r.Data
+		if r.Body != nil {
+			body = r.Body
+		}
+		bodyData, err := json.Marshal(body)
 		if err != nil {
-			// skylog.Errorf("RequestUtil Send create body data error: %v | data: %v", err, r.Data)
-			fmt.Println("RequestUtil Send create body data error: %v | data: %v", err, r.Data)
+			Logger().ErrorContext(ctx, "RequestUtil Send create body data error", "error", err, "data", RedactLogValue(r.Data))
 			return nil, err
 		}
 		data = bytes.NewReader(bodyData)
@@ -169,30 +209,24 @@ func (r *RequestUtil) SendRaw() (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	// create http client
-	if r.InsecureSkipVerify < 0 {
-		r.Transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	} else if r.InsecureSkipVerify > 0 {
-		r.Transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
-	}
-	client := &http.Client{
-		Transport: r.Transport,
-		Timeout:   r.Timeout,
-	}
 	// send request
-	res, err := client.Do(req)
+	res, err := r.ToHttpClient().Do(ctx, req)
 	if res != nil && res.StatusCode != http.StatusOK {
 		bodyData, _ := json.Marshal(r.Data)
-		// skylog.Infof("SendRequest [%v]%v -> %v\nData: %v\n", r.Method, r.Url, res.Status, string(bodyData))
-		fmt.Println("SendRequest [%v]%v -> %v\nData: %v\n", r.Method, r.Url, res.Status, string(bodyData))
+		Logger().WarnContext(ctx, "RequestUtil Send response error", "method", r.Method, "url", r.Url, "status", res.StatusCode, "data", logBody(bodyData))
 	}
 
 	return res, err
 }
 
 func (r *RequestUtil) Send() ([]byte, bool, error) {
+	return r.SendWithContext(context.Background())
+}
+
+// SendWithContext function send request bound to ctx and read response body
+func (r *RequestUtil) SendWithContext(ctx context.Context) ([]byte, bool, error) {
 	// send request
-	res, err := r.SendRaw()
+	res, err := r.SendRawWithContext(ctx)
 	if err != nil {
 		return nil, false, err
 	}
@@ -9,13 +9,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
 	"google.golang.org/grpc/metadata"
-	// "suntech.com.vn/skylib/skylog.git/skylog"
 )
 
 const (
@@ -23,32 +21,53 @@ const (
 )
 
 func BuildServiceUrl(urlOrServiceAddr, path string) (string, error) {
+	return BuildServiceUrlContext(context.Background(), urlOrServiceAddr, path)
+}
+
+// BuildServiceUrlContext function same as BuildServiceUrl, service names are resolved within ctx
+func BuildServiceUrlContext(ctx context.Context, urlOrServiceAddr, path string) (string, error) {
 	if strings.HasPrefix(urlOrServiceAddr, "http") {
 		return urlOrServiceAddr, nil
 	}
 
+	if !strings.HasPrefix(path, "/") {
+		path = fmt.Sprintf("/%v", path)
+	}
+
+	// service name registered in service resolver
+	if httpAddr, ok := resolveServiceHttpAddr(ctx, urlOrServiceAddr); ok {
+		return fmt.Sprintf("http://%v%v", httpAddr, path), nil
+	}
+
 	parts := strings.Split(urlOrServiceAddr, ":")
 	if len(parts) < 2 {
-		// skylog.Errorf("service address is incorrect: %v", urlOrServiceAddr)
-		fmt.Println("service address is incorrect: %v", urlOrServiceAddr)
+		Logger().Error("service address is incorrect", "address", urlOrServiceAddr)
 		return "", errors.New("service address is incorrect")
 	}
 	grpcPort, _ := strconv.Atoi(parts[1])
 
-	if !strings.HasPrefix(path, "/") {
-		path = fmt.Sprintf("/%v", path)
-	}
 	url := fmt.Sprintf("http://%v:%v%v", parts[0], grpcPort+1, path)
 
 	return url, nil
 }
 
 func SendRawFromRequest(method, urlOrServiceAddr, path, accessToken string, body map[string]interface{}, callFrom interface{}) (*http.Response, error) {
+	return SendRawBodyFromRequest(method, urlOrServiceAddr, path, accessToken, body, callFrom)
+}
+
+// SendRawBodyFromRequest function same as SendRawFromRequest but accept any json body (struct, slice, map)
+func SendRawBodyFromRequest(method, urlOrServiceAddr, path, accessToken string, body interface{}, callFrom interface{}) (*http.Response, error) {
+	return sendRawBodyFromRequest(DefaultHttpClient, method, urlOrServiceAddr, path, accessToken, body, callFrom)
+}
+
+func sendRawBodyFromRequest(client *HttpClient, method, urlOrServiceAddr, path, accessToken string, body interface{}, callFrom interface{}) (*http.Response, error) {
+	// request context, used for cancellation, deadline and logs
+	ctx := withServiceCircuitBreakerKey(callFromContext(callFrom), urlOrServiceAddr)
+
 	// build url
-	serviceUrl, err := BuildServiceUrl(urlOrServiceAddr, path)
+	serviceUrl, err := BuildServiceUrlContext(ctx, urlOrServiceAddr, path)
 	if err != nil {
-		// skylog.Errorf("SendRawFromRequest build service url error: %v", err)
-		fmt.Println("SendRawFromRequest build service url error: %v", err)
+		Logger().ErrorContext(ctx, "SendRawFromRequest build service url error", "address", urlOrServiceAddr, "path", path, "error", err)
 		return nil, err
 	}
 
@@ -57,62 +76,33 @@ func SendRawFromRequest(method, urlOrServiceAddr, path, accessToken string, body
 	if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
 		bodyData, err := json.Marshal(body)
 		if err != nil {
-			// skylog.Errorf("SendRawFromRequest create body data error: %v | data: %v", err, body)
-			fmt.Println("SendRawFromRequest create body data error: %v | data: %v", err, body)
+			Logger().ErrorContext(ctx, "SendRawFromRequest create body data error", "error", err, "data", RedactLogValue(body))
 			return nil, err
 		}
 		data = bytes.NewReader(bodyData)
 	}
 	// create request
-	req, err := http.NewRequest(method, serviceUrl, data)
+	req, err := http.NewRequestWithContext(ctx, method, serviceUrl, data)
 	if err != nil {
-		// skylog.Errorf("SendRawFromRequest create request error: %v", err)
-		fmt.Println("SendRawFromRequest create request error: %v", err)
+		Logger().ErrorContext(ctx, "SendRawFromRequest create request error", "url", serviceUrl, "error", err)
 		return nil, err
 	}
 
-	// set User-Agent
+	// propagate headers
 	if callFrom != nil {
-		if reflect.TypeOf(callFrom).String() == "*http.Request" {
-			fromReq := callFrom.(*http.Request)
-			req.Header.Set("User-Agent", fromReq.Header.Get("User-Agent"))
-
-			xRealIP := fromReq.Header.Get("X-Real-IP")
-			if len(xRealIP) > 0 {
-				req.Header.Set("X-Real-IP", xRealIP)
-			}
-
-			xForwardedFor := fromReq.Header.Get("X-Forwarded-For")
-			if len(xForwardedFor) > 0 {
-				req.Header.Set("X-Forwarded-For", xForwardedFor)
-			}
-
-			xForwardedHost := fromReq.Header.Get("X-Forwarded-Host")
-			if len(xForwardedHost) > 0 {
-				req.Header.Set("X-Forwarded-Host", xForwardedHost)
-			} else {
+		if fromReq, ok := callFrom.(*http.Request); ok {
+			outboundPropagationPolicy().Without("authorization").HeaderToHeader(fromReq.Header, req.Header)
+			if len(req.Header.Get("X-Forwarded-Host")) == 0 {
 				req.Header.Set("X-Forwarded-Host", fromReq.Host)
 			}
 
 			if len(fromReq.RemoteAddr) > 0 {
 				req.RemoteAddr = fromReq.RemoteAddr
 			}
-		} else {
-			fromContext := callFrom.(context.Context)
+		} else if fromContext, ok := callFrom.(context.Context); ok {
 			// Extract metadata from gRPC context
-			md, ok := metadata.FromIncomingContext(fromContext)
-			if !ok {
-				md = metadata.New(nil)
-			}
-			// Add metadata as HTTP headers
-			for key, values := range md {
-				// Skip pseudo-headers like ":authority" or other invalid header names
-				if key == ":authority" || key == ":method" || key == ":path" || key == ":scheme" {
-					continue
-				}
-				for _, value := range values {
-					req.Header.Add(key, value)
-				}
+			if md, ok := metadata.FromIncomingContext(fromContext); ok {
+				outboundPropagationPolicy().MetadataToHeader(md, req.Header)
 			}
 		}
 	}
@@ -128,11 +118,18 @@ func SendRawFromRequest(method, urlOrServiceAddr, path, accessToken string, body
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	// create http client
-	client := http.Client{}
 	// send request
-	res, err := client.Do(req)
-	return res, err
+	return client.Do(ctx, req)
+}
+
+// callFromContext function return context of a *http.Request or context.Context caller, background otherwise
+func callFromContext(callFrom interface{}) context.Context {
+	if fromReq, ok := callFrom.(*http.Request); ok && fromReq != nil {
+		return fromReq.Context()
+	} else if fromContext, ok := callFrom.(context.Context); ok && fromContext != nil {
+		return fromContext
+	}
+	return context.Background()
 }
 
 func SendRawRequest(method, urlOrServiceAddr, path, accessToken string, body map[string]interface{}) (*http.Response, error) {
@@ -140,37 +137,49 @@ func SendRawRequest(method, urlOrServiceAddr, path, accessToken string, body map
 	return SendRawFromRequest(method, urlOrServiceAddr, path, accessToken, body, nil)
 }
 
+// SendMultiPartForm function send form without DefaultHttpClientTimeout, bounded by ctx only
 func SendMultiPartForm(method, urlOrServiceAddr, path string, body io.Reader, contentType string, ctx context.Context) (*http.Response, error) {
+	return sendMultiPartForm(streamHttpClient(), method, urlOrServiceAddr, path, body, contentType, ctx)
+}
+
+func sendMultiPartForm(client *HttpClient, method, urlOrServiceAddr, path string, body io.Reader, contentType string, ctx context.Context) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = withServiceCircuitBreakerKey(ctx, urlOrServiceAddr)
+
+	// create request
+	req, err := NewRequestWithIncomingContext(ctx, method, urlOrServiceAddr, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// send request
+	return client.Do(ctx, req)
+}
+
+// NewRequestWithIncomingContext function create request to service, copy gRPC incoming metadata and access token of ctx to headers
+func NewRequestWithIncomingContext(ctx context.Context, method, urlOrServiceAddr, path string, body io.Reader) (*http.Request, error) {
 	// build url
-	serviceUrl, err := BuildServiceUrl(urlOrServiceAddr, path)
+	serviceUrl, err := BuildServiceUrlContext(ctx, urlOrServiceAddr, path)
 	if err != nil {
-		// skylog.Errorf("SendMultiPartForm build service url error: %v", err)
-		fmt.Println("SendMultiPartForm build service url error: %v", err)
+		Logger().ErrorContext(ctx, "NewRequestWithIncomingContext build service url error", "address", urlOrServiceAddr, "path", path, "error", err)
 		return nil, err
 	}
 
 	// create request
-	req, err := http.NewRequest(method, serviceUrl, body)
+	req, err := http.NewRequestWithContext(withServiceCircuitBreakerKey(ctx, urlOrServiceAddr), method, serviceUrl, body)
 	if err != nil {
-		// skylog.Errorf("SendMultiPartForm create request error: %v", err)
-		fmt.Println("SendMultiPartForm create request error: %v", err)
+		Logger().ErrorContext(ctx, "NewRequestWithIncomingContext create request error", "url", serviceUrl, "error", err)
 		return nil, err
 	}
 
 	// Extract metadata from gRPC context
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		md = metadata.New(nil)
-	}
-	// Add metadata as HTTP headers
-	for key, values := range md {
-		// Skip pseudo-headers like ":authority" or other invalid header names
-		if key == ":authority" || key == ":method" || key == ":path" || key == ":scheme" {
-			continue
-		}
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		outboundPropagationPolicy().MetadataToHeader(md, req.Header)
 	}
 
 	accessToken, _, _ := GetLoginAccessToken(ctx)
@@ -181,34 +190,28 @@ func SendMultiPartForm(method, urlOrServiceAddr, path string, body io.Reader, co
 		}
 		req.Header.Set("Authorization", accessToken)
 	}
-	if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
-		req.Header.Set("Content-Type", contentType)
-	}
-
-	// create http client
-	client := http.Client{}
-	// send request
-	return client.Do(req)
+	return req, nil
 }
 
 func ForwardRequest(method, urlOrServiceAddr, path, accessToken string, body map[string]interface{}, fromReqOrContext interface{}) ([]byte, error) {
 	// send request
 	resp, err := SendRawFromRequest(method, urlOrServiceAddr, path, accessToken, body, fromReqOrContext)
 	if err != nil {
-		// skylog.Errorf("SendRequest send request error 1: %v", err)
-		fmt.Println("SendRequest send request error 1: %v", err)
+		Logger().ErrorContext(callFromContext(fromReqOrContext), "ForwardRequest send request error", "method", method, "address", urlOrServiceAddr, "path", path, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	resData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
 	if resp.StatusCode == http.StatusOK {
 		return resData, nil
 	}
-	// skylog.Errorf("SendRequest send request error 2: %v", string(resData))
-	fmt.Println("SendRequest send request error 2: %v", string(resData))
-	return resData, CallRESTAPIError
+	Logger().ErrorContext(callFromContext(fromReqOrContext), "ForwardRequest response error", "method", method, "address", urlOrServiceAddr, "path", path, "status", resp.StatusCode, "body", logBody(resData))
+	return resData, NewHTTPError(resp, resData)
 }
 
 func SendRequest(method, urlOrServiceAddr, path, accessToken string, body map[string]interface{}) ([]byte, error) {
@@ -236,23 +239,20 @@ func RestPostWithContext(urlOrServiceAddr, path string, values map[string]interf
 	return ForwardRequest(http.MethodPost, urlOrServiceAddr, path, accessToken, values, ctx)
 }
 
+// RestDownloadFile function download file into memory, bounded by ctx only
 func RestDownloadFile(urlOrServiceAddr, path string, ctx context.Context) ([]byte, string, string, string, error) {
 	accessToken, _, _ := GetLoginAccessToken(ctx)
-	// send request
-	resp, err := SendRawFromRequest(http.MethodGet, urlOrServiceAddr, path, accessToken, nil, ctx)
+	// send request, files are bounded by ctx only
+	resp, err := sendRawBodyFromRequest(streamHttpClient(), http.MethodGet, urlOrServiceAddr, path, accessToken, nil, ctx)
 	if err != nil {
-		// skylog.Errorf("RestDownloadFile send request error: %v", err)
-		fmt.Println("RestDownloadFile send request error: %v", err)
+		Logger().ErrorContext(ctx, "RestDownloadFile send request error", "address", urlOrServiceAddr, "path", path, "error", err)
 		return nil, "", "", "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
 		// Get the file name from the Content-Disposition header
-		fileName := resp.Header.Get("Content-Disposition")
-		fileName = strings.TrimSpace(strings.Split(fileName, ";")[1])
-		fileName = strings.TrimSpace(strings.Split(fileName, "=")[1])
-		fileName = strings.Replace(fileName, "\"", "", -1)
+		fileName := ParseContentDispositionFileName(resp.Header.Get("Content-Disposition"))
 
 		// Get the content type from the Content-Type header
 		contentType := resp.Header.Get("Content-Type")
@@ -262,8 +262,7 @@ func RestDownloadFile(urlOrServiceAddr, path string, ctx context.Context) ([]byt
 		if dateStr != "" {
 			date, err := time.Parse(http.TimeFormat, dateStr)
 			if err != nil {
-				// skylog.Errorf("RestDownloadFile parse time error; time=%v; err:%v", dateStr, err)
-				fmt.Println("RestDownloadFile parse time error; time=%v; err:%v", dateStr, err)
+				Logger().WarnContext(ctx, "RestDownloadFile parse time error", "time", dateStr, "error", err)
 				dateStr = ""
 			} else {
 				dateStr = ToStr(date.UTC().UnixMilli())
@@ -273,24 +272,21 @@ func RestDownloadFile(urlOrServiceAddr, path string, ctx context.Context) ([]byt
 		// Read the response body into a byte array
 		bytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			// skylog.Errorf("RestDownloadFile read body error: %v", err)
-			fmt.Println("RestDownloadFile read body error: %v", err)
+			Logger().ErrorContext(ctx, "RestDownloadFile read body error", "error", err)
 			return nil, fileName, contentType, dateStr, err
 		}
 
 		return bytes, fileName, contentType, dateStr, nil
 	} else {
-		// skylog.Errorf("RestDownloadFile call request error: %v", resp.StatusCode)
-		fmt.Println("RestDownloadFile call request error: %v", resp.StatusCode)
+		Logger().ErrorContext(ctx, "RestDownloadFile response error", "address", urlOrServiceAddr, "path", path, "status", resp.StatusCode)
 		// Read the response body into a byte array
 		bytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			// skylog.Errorf("RestDownloadFile read body error: %v", err)
-			fmt.Println("RestDownloadFile read body error: %v", err)
+			Logger().ErrorContext(ctx, "RestDownloadFile read body error", "error", err)
 			return nil, "", "", "", err
 		}
 
-		return bytes, "", "", "", errors.New(string(bytes))
+		return bytes, "", "", "", NewHTTPError(resp, bytes)
 	}
 }
 
@@ -298,21 +294,25 @@ func RestUploadFile(urlOrServiceAddr, path string, body io.Reader, contentType s
 	// send request
 	resp, err := SendMultiPartForm(http.MethodPost, urlOrServiceAddr, path, body, contentType, ctx)
 	if err != nil {
-		// skylog.Errorf("RestUploadFile send multi part file error: %v", err)
-		fmt.Println("RestUploadFile send multi part file error: %v", err)
+		Logger().ErrorContext(ctx, "RestUploadFile send multi part file error", "address", urlOrServiceAddr, "path", path, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	resData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	if resp.StatusCode == http.StatusOK {
 		return resData, nil
 	}
-	// skylog.Errorf("RestUploadFile send multi part file error: %v", string(resData))
-	fmt.Println("RestUploadFile send multi part file error: %v", string(resData))
-	return resData, CallRESTAPIError
+	Logger().ErrorContext(ctx, "RestUploadFile response error", "address", urlOrServiceAddr, "path", path, "status", resp.StatusCode, "body", logBody(resData))
+	return resData, NewHTTPError(resp, resData)
 }
 
+// IsValidChecksum function verify unsigned sha256 checksum of url.
+//
+// Deprecated: checksum has no secret and can be forged, use UrlSigner.VerifyRequest
 func IsValidChecksum(r *http.Request) bool {
 	// get client checksum from url
 	checksum := r.URL.Query().Get("checksum")
@@ -328,23 +328,28 @@ func IsValidChecksum(r *http.Request) bool {
 		if checksum == serverChecksum {
 			return true
 		}
-		// skylog.Infof("IsValidChecksum: checksum = %v <> %v = serverChecksum (%v)", checksum, serverChecksum, checksumUrl)
-		fmt.Println("IsValidChecksum: checksum = %v <> %v = serverChecksum (%v)", checksum, serverChecksum, checksumUrl)
+		Logger().WarnContext(r.Context(), "IsValidChecksum checksum mismatch", "checksum", checksum, "serverChecksum", serverChecksum, "url", checksumUrl)
 	}
 	return false
 }
 
+// IsValidChecksumWithContext function verify unsigned sha256 checksum of url.
+//
+// Deprecated: checksum has no secret and can be forged, use UrlSigner.VerifyContext
 func IsValidChecksumWithContext(ctx context.Context) bool {
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		if url, hasUrl := md["pattern"]; hasUrl && len(url) > 0 {
 			return IsValidChecksumWithUrl(url[0])
 		} else {
-			fmt.Printf("md: %v\n", md)
+			Logger().DebugContext(ctx, "IsValidChecksumWithContext missing pattern metadata")
 		}
 	}
 	return false
 }
 
+// IsValidChecksumWithUrl function verify unsigned sha256 checksum of url.
+//
+// Deprecated: checksum has no secret and can be forged, use UrlSigner.VerifyURL
 func IsValidChecksumWithUrl(urlStr string) bool {
 	u, err := url.Parse(urlStr)
 	if err != nil {
@@ -364,8 +369,7 @@ func IsValidChecksumWithUrl(urlStr string) bool {
 		if checksum == serverChecksum {
 			return true
 		}
-		// skylog.Infof("IsValidChecksumWithUrl: client checksum = %v <> %v = serverChecksum (%v)", checksum, serverChecksum, checksumUrl)
-		fmt.Println("IsValidChecksumWithUrl: client checksum = %v <> %v = serverChecksum (%v)", checksum, serverChecksum, checksumUrl)
+		Logger().Warn("IsValidChecksumWithUrl checksum mismatch", "checksum", checksum, "serverChecksum", serverChecksum, "url", checksumUrl)
 	}
 	return false
 }
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileMetadata struct holds file info read from response headers
+type FileMetadata struct {
+	FileName      string
+	ContentType   string
+	ContentLength int64 // -1 when unknown
+	LastModified  time.Time
+	// Offset is the first byte of body in the file, 0 when server ignored Range
+	Offset int64
+	// TotalLength is the full file size, -1 when unknown
+	TotalLength int64
+}
+
+// ProgressFunc is called with the total bytes transferred so far
+type ProgressFunc func(transferred int64)
+
+// ProgressReader struct report bytes read through OnProgress
+type ProgressReader struct {
+	Reader     io.Reader
+	OnProgress ProgressFunc
+	total      int64
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		total := atomic.AddInt64(&r.total, int64(n))
+		if r.OnProgress != nil {
+			r.OnProgress(total)
+		}
+	}
+	return n, err
+}
+
+// ParseContentDispositionFileName function return file name of Content-Disposition header (RFC 6266),
+// filename* (RFC 5987, UTF-8) is preferred over filename
+func ParseContentDispositionFileName(header string) string {
+	if header == "" {
+		return ""
+	}
+	// mime decodes filename* into "filename"
+	if _, params, err := mime.ParseMediaType(header); err == nil {
+		return params["filename"]
+	}
+
+	// fallback for malformed headers
+	var fileName string
+	for _, part := range strings.Split(header, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "filename*":
+			// charset'language'percent-encoded
+			parts := strings.SplitN(value, "'", 3)
+			if len(parts) == 3 {
+				if decoded, err := url.PathUnescape(parts[2]); err == nil {
+					return decoded
+				}
+			}
+		case "filename":
+			fileName = value
+		}
+	}
+	return fileName
+}
+
+// ParseFileMetadata function read FileMetadata from response headers
+func ParseFileMetadata(resp *http.Response) FileMetadata {
+	meta := FileMetadata{
+		FileName:      ParseContentDispositionFileName(resp.Header.Get("Content-Disposition")),
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		TotalLength:   -1,
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if date, err := http.ParseTime(lastModified); err == nil {
+			meta.LastModified = date.UTC()
+		}
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes start-end/total
+		contentRange := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+		byteRange, total, _ := strings.Cut(contentRange, "/")
+		start, _, _ := strings.Cut(byteRange, "-")
+		meta.Offset, _ = strconv.ParseInt(start, 10, 64)
+		if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+			meta.TotalLength = size
+		}
+	} else {
+		meta.TotalLength = resp.ContentLength
+	}
+	return meta
+}
+
+// RestDownloadStream function download file without buffering, caller must close the returned body.
+// offset > 0 resumes download with a Range request
+func RestDownloadStream(urlOrServiceAddr, path string, offset int64, ctx context.Context) (io.ReadCloser, FileMetadata, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := NewRequestWithIncomingContext(ctx, http.MethodGet, urlOrServiceAddr, path, nil)
+	if err != nil {
+		return nil, FileMetadata{}, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+
+	resp, err := streamHttpClient().Do(ctx, req)
+	if err != nil {
+		return nil, FileMetadata{}, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		resData, _ := io.ReadAll(io.LimitReader(resp.Body, BUFFER_SIZE*16))
+		return nil, FileMetadata{}, NewHTTPError(resp, resData)
+	}
+	return resp.Body, ParseFileMetadata(resp), nil
+}
+
+// UploadFile struct describe a file for RestUploadFileStream
+type UploadFile struct {
+	FieldName   string // default "file"
+	FileName    string
+	ContentType string // default "application/octet-stream"
+	Reader      io.Reader
+	// Fields are other form values sent before the file
+	Fields     map[string]string
+	OnProgress ProgressFunc
+}
+
+// RestUploadFileStream function upload file as multipart form without buffering it in memory
+func RestUploadFileStream(urlOrServiceAddr, path string, file UploadFile, ctx context.Context) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeMultipartFile(writer, file))
+	}()
+
+	resp, err := sendMultiPartForm(streamHttpClient(), http.MethodPost, urlOrServiceAddr, path, pr, writer.FormDataContentType(), ctx)
+	if err != nil {
+		// stop writer goroutine
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	pr.Close()
+
+	resData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resData, nil
+	}
+	return resData, NewHTTPError(resp, resData)
+}
+
+func writeMultipartFile(writer *multipart.Writer, file UploadFile) error {
+	for key, value := range file.Fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+
+	fieldName := file.FieldName
+	if fieldName == "" {
+		fieldName = "file"
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     fieldName,
+		"filename": file.FileName,
+	}))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = file.Reader
+	if file.OnProgress != nil {
+		reader = &ProgressReader{Reader: file.Reader, OnProgress: file.OnProgress}
+	}
+	if _, err := io.CopyBuffer(part, reader, make([]byte, BUFFER_SIZE)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
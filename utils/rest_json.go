@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// RestError struct holds a non 2xx response of the typed REST helpers
+type RestError struct {
+	*HTTPError
+	// ErrorBody is the json decoded Body, nil when Body is not json
+	ErrorBody interface{}
+}
+
+func (e *RestError) Unwrap() error {
+	return e.HTTPError
+}
+
+// DecodeJSONResponse function read response, decode 2xx body into T, otherwise return *RestError
+func DecodeJSONResponse[T any](res *http.Response) (T, error) {
+	var result T
+	defer res.Body.Close()
+
+	resData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return result, err
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		restErr := &RestError{
+			HTTPError: NewHTTPError(res, resData),
+		}
+		var errorBody interface{}
+		if len(resData) > 0 && json.Unmarshal(resData, &errorBody) == nil {
+			restErr.ErrorBody = errorBody
+		}
+		return result, restErr
+	}
+
+	if len(resData) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(resData, &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// ForwardRequestJSON function send typed body and decode response into Resp
+func ForwardRequestJSON[Req, Resp any](method, urlOrServiceAddr, path, accessToken string, body Req, fromReqOrContext interface{}) (Resp, error) {
+	resp, err := SendRawBodyFromRequest(method, urlOrServiceAddr, path, accessToken, body, fromReqOrContext)
+	if err != nil {
+		var result Resp
+		return result, err
+	}
+	return DecodeJSONResponse[Resp](resp)
+}
+
+// RestGetJSON function send GET request and decode response into T
+func RestGetJSON[T any](urlOrServiceAddr, path string, accessToken string) (T, error) {
+	return ForwardRequestJSON[interface{}, T](http.MethodGet, urlOrServiceAddr, path, accessToken, nil, nil)
+}
+
+// RestGetJSONWithContext function send GET request with token and headers from ctx, decode response into T
+func RestGetJSONWithContext[T any](urlOrServiceAddr, path string, ctx context.Context) (T, error) {
+	accessToken, _, _ := GetLoginAccessToken(ctx)
+	return ForwardRequestJSON[interface{}, T](http.MethodGet, urlOrServiceAddr, path, accessToken, nil, ctx)
+}
+
+// RestPostJSON function send POST request with typed body and decode response into Resp
+func RestPostJSON[Req, Resp any](urlOrServiceAddr, path string, values Req, accessToken string) (Resp, error) {
+	return ForwardRequestJSON[Req, Resp](http.MethodPost, urlOrServiceAddr, path, accessToken, values, nil)
+}
+
+// RestPostJSONWithContext function send POST request with token and headers from ctx, decode response into Resp
+func RestPostJSONWithContext[Req, Resp any](urlOrServiceAddr, path string, values Req, ctx context.Context) (Resp, error) {
+	accessToken, _, _ := GetLoginAccessToken(ctx)
+	return ForwardRequestJSON[Req, Resp](http.MethodPost, urlOrServiceAddr, path, accessToken, values, ctx)
+}
+
+// SendJSON function send RequestUtil bound to ctx and decode response into T
+func SendJSON[T any](ctx context.Context, r *RequestUtil) (T, error) {
+	res, err := r.SendRawWithContext(ctx)
+	if err != nil {
+		var result T
+		return result, err
+	}
+	return DecodeJSONResponse[T](res)
+}
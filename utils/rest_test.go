@@ -0,0 +1,281 @@
+package utils_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinhduc5984/mylib/utils"
+	"github.com/vinhduc5984/mylib/utils/resttest"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestBuildServiceUrl(t *testing.T) {
+	tests := []struct {
+		addr, path, want string
+		wantErr          bool
+	}{
+		{addr: "core:50051", path: "/users", want: "http://core:50052/users"},
+		{addr: "core:50051", path: "users", want: "http://core:50052/users"},
+		{addr: "core", path: "/users", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := utils.BuildServiceUrl(tt.addr, tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("BuildServiceUrl(%q, %q) error = %v, wantErr %v", tt.addr, tt.path, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("BuildServiceUrl(%q, %q) = %q, want %q", tt.addr, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestForwardRequestSuccess(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPost, "/users").RespondJSON(http.StatusOK, map[string]interface{}{"id": 1})
+
+	body, err := utils.ForwardRequest(http.MethodPost, service.ServiceAddr(), "/users", "token", map[string]interface{}{"name": "a"}, nil)
+	if err != nil {
+		t.Fatalf("ForwardRequest error = %v", err)
+	}
+	if string(body) != `{"id":1}` {
+		t.Errorf("ForwardRequest body = %s", body)
+	}
+	service.ExpectHeader(t, http.MethodPost, "/users", "Authorization", "Bearer token")
+	service.ExpectHeader(t, http.MethodPost, "/users", "Content-Type", "application/json")
+	req, _ := service.LastRequest(http.MethodPost, "/users")
+	if string(req.Body) != `{"name":"a"}` {
+		t.Errorf("request body = %s", req.Body)
+	}
+}
+
+func TestForwardRequestNon2xx(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPost, "/users").RespondJSON(http.StatusBadRequest, map[string]interface{}{"message": "invalid name"})
+
+	body, err := utils.ForwardRequest(http.MethodPost, service.ServiceAddr(), "/users", "", nil, nil)
+	httpErr, ok := utils.AsHTTPError(err)
+	if !ok {
+		t.Fatalf("ForwardRequest error = %v, want HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusBadRequest || httpErr.Message() != "invalid name" {
+		t.Errorf("HTTPError = %v", httpErr)
+	}
+	if !errors.Is(err, utils.CallRESTAPIError) {
+		t.Errorf("errors.Is(err, CallRESTAPIError) = false")
+	}
+	if !strings.Contains(string(body), "invalid name") {
+		t.Errorf("ForwardRequest body = %s", body)
+	}
+	// POST is not retried
+	if served := service.Handle(http.MethodPost, "/users").Served(); served != 1 {
+		t.Errorf("served = %v, want 1", served)
+	}
+}
+
+func TestForwardRequestRetry(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	route := service.Handle(http.MethodGet, "/users")
+	route.Respond(http.StatusServiceUnavailable, nil, "Retry-After", "0").
+		Respond(http.StatusServiceUnavailable, nil, "Retry-After", "0").
+		RespondJSON(http.StatusOK, []int{1})
+
+	body, err := utils.ForwardRequest(http.MethodGet, service.ServiceAddr(), "/users", "", nil, nil)
+	if err != nil {
+		t.Fatalf("ForwardRequest error = %v", err)
+	}
+	if string(body) != "[1]" {
+		t.Errorf("ForwardRequest body = %s", body)
+	}
+	if served := route.Served(); served != 3 {
+		t.Errorf("served = %v, want 3", served)
+	}
+}
+
+func TestForwardRequestContextDeadline(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodGet, "/slow").Delay(time.Second).RespondJSON(http.StatusOK, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := utils.ForwardRequest(http.MethodGet, service.ServiceAddr(), "/slow", "", nil, ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ForwardRequest error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestForwardRequestPropagatesIncomingMetadata(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodGet, "/users").RespondJSON(http.StatusOK, nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer incoming",
+		"x-api-key", "secret",
+		"x-request-id", "req-1",
+		"connection", "keep-alive",
+		"x-internal", "value",
+	))
+	if _, err := utils.ForwardRequest(http.MethodGet, service.ServiceAddr(), "/users", "", nil, ctx); err != nil {
+		t.Fatalf("ForwardRequest error = %v", err)
+	}
+	service.ExpectHeader(t, http.MethodGet, "/users", "Authorization", "Bearer incoming")
+	service.ExpectHeader(t, http.MethodGet, "/users", "X-Request-Id", "req-1")
+	service.ExpectNoHeader(t, http.MethodGet, "/users", "X-Api-Key")
+	service.ExpectNoHeader(t, http.MethodGet, "/users", "X-Internal")
+
+	// an explicit access token replaces the incoming one
+	if _, err := utils.ForwardRequest(http.MethodGet, service.ServiceAddr(), "/users", "token", nil, ctx); err != nil {
+		t.Fatalf("ForwardRequest error = %v", err)
+	}
+	service.ExpectHeader(t, http.MethodGet, "/users", "Authorization", "Bearer token")
+	service.ExpectNoHeader(t, http.MethodGet, "/users", "X-Api-Key")
+}
+
+func TestSendRawBodyFromRequestPropagatesHeaders(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPost, "/users").RespondJSON(http.StatusOK, nil)
+
+	fromReq, _ := http.NewRequest(http.MethodPost, "http://gateway.local/users", nil)
+	fromReq.Header.Set("Authorization", "Bearer incoming")
+	fromReq.Header.Set("X-Api-Key", "secret")
+	fromReq.Header.Set("X-Request-Id", "req-1")
+	fromReq.Header.Set("User-Agent", "client")
+	fromReq.Header.Set("Cookie", "session=1")
+
+	res, err := utils.SendRawBodyFromRequest(http.MethodPost, service.ServiceAddr(), "/users", "token", []string{"a"}, fromReq)
+	if err != nil {
+		t.Fatalf("SendRawBodyFromRequest error = %v", err)
+	}
+	res.Body.Close()
+	// the caller authorization is replaced by accessToken, never forwarded from the request
+	service.ExpectHeader(t, http.MethodPost, "/users", "Authorization", "Bearer token")
+	service.ExpectHeader(t, http.MethodPost, "/users", "X-Request-Id", "req-1")
+	service.ExpectHeader(t, http.MethodPost, "/users", "User-Agent", "client")
+	service.ExpectHeader(t, http.MethodPost, "/users", "X-Forwarded-Host", "gateway.local")
+	service.ExpectNoHeader(t, http.MethodPost, "/users", "X-Api-Key")
+	service.ExpectNoHeader(t, http.MethodPost, "/users", "Cookie")
+
+	res, err = utils.SendRawBodyFromRequest(http.MethodPost, service.ServiceAddr(), "/users", "", []string{"a"}, fromReq)
+	if err != nil {
+		t.Fatalf("SendRawBodyFromRequest error = %v", err)
+	}
+	res.Body.Close()
+	service.ExpectNoHeader(t, http.MethodPost, "/users", "Authorization")
+}
+
+func TestRestDownloadFile(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	service.Handle(http.MethodGet, "/files/1").Respond(http.StatusOK, []byte("content"),
+		"Content-Type", "text/plain",
+		"Content-Disposition", `attachment; filename="report.txt"`,
+		"Last-Modified", lastModified.Format(http.TimeFormat))
+
+	data, fileName, contentType, date, err := utils.RestDownloadFile(service.ServiceAddr(), "/files/1", context.Background())
+	if err != nil {
+		t.Fatalf("RestDownloadFile error = %v", err)
+	}
+	if string(data) != "content" || fileName != "report.txt" || contentType != "text/plain" {
+		t.Errorf("RestDownloadFile = %q, %q, %q", data, fileName, contentType)
+	}
+	if date != utils.ToStr(lastModified.UnixMilli()) {
+		t.Errorf("RestDownloadFile date = %q", date)
+	}
+}
+
+func TestRestDownloadFileNotFound(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodGet, "/files/2").Respond(http.StatusNotFound, []byte("missing"))
+
+	data, _, _, _, err := utils.RestDownloadFile(service.ServiceAddr(), "/files/2", context.Background())
+	httpErr, ok := utils.AsHTTPError(err)
+	if !ok || httpErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("RestDownloadFile error = %v, want 404 HTTPError", err)
+	}
+	if string(data) != "missing" {
+		t.Errorf("RestDownloadFile body = %q", data)
+	}
+}
+
+func TestRestUploadFile(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPost, "/files").RespondJSON(http.StatusOK, map[string]string{"id": "f1"})
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "a.txt")
+	part.Write([]byte("hello"))
+	writer.Close()
+
+	res, err := utils.RestUploadFile(service.ServiceAddr(), "/files", &body, writer.FormDataContentType(), context.Background())
+	if err != nil {
+		t.Fatalf("RestUploadFile error = %v", err)
+	}
+	if string(res) != `{"id":"f1"}` {
+		t.Errorf("RestUploadFile body = %s", res)
+	}
+	service.ExpectHeader(t, http.MethodPost, "/files", "Content-Type", writer.FormDataContentType())
+	req, _ := service.LastRequest(http.MethodPost, "/files")
+	if !bytes.Contains(req.Body, []byte("hello")) || !bytes.Contains(req.Body, []byte(`filename="a.txt"`)) {
+		t.Errorf("uploaded body = %s", req.Body)
+	}
+}
+
+func TestRestUploadFileNon2xx(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPost, "/files").Respond(http.StatusRequestEntityTooLarge, []byte("too large"))
+
+	_, err := utils.RestUploadFile(service.ServiceAddr(), "/files", strings.NewReader("x"), "text/plain", context.Background())
+	if httpErr, ok := utils.AsHTTPError(err); !ok || httpErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("RestUploadFile error = %v, want 413 HTTPError", err)
+	}
+}
+
+func TestNewRequestFromMap(t *testing.T) {
+	service := resttest.NewFakeService()
+	defer service.Close()
+	service.Handle(http.MethodPut, "/items").RespondJSON(http.StatusOK, map[string]bool{"ok": true})
+
+	r, err := utils.NewRequestFromMap(map[string]interface{}{
+		"method":        http.MethodPut,
+		"url":           service.URL() + "/items",
+		"timeout":       "5",
+		"authorization": "secret",
+		"headers":       `{"X-Tenant":"t1"}`,
+		"data":          map[string]interface{}{"qty": 2},
+	})
+	if err != nil {
+		t.Fatalf("NewRequestFromMap error = %v", err)
+	}
+	if r.Method != http.MethodPut || r.Timeout != 5*time.Second {
+		t.Errorf("NewRequestFromMap = %+v", r)
+	}
+
+	body, ok, err := r.Send()
+	if err != nil || !ok {
+		t.Fatalf("Send = %v, %v", ok, err)
+	}
+	if string(body) != `{"ok":true}` {
+		t.Errorf("Send body = %s", body)
+	}
+	service.ExpectHeader(t, http.MethodPut, "/items", "X-Tenant", "t1")
+	service.ExpectHeader(t, http.MethodPut, "/items", "Authorization", "Bearer secret")
+	req, _ := service.LastRequest(http.MethodPut, "/items")
+	if string(req.Body) != `{"qty":2}` {
+		t.Errorf("request body = %s", req.Body)
+	}
+}
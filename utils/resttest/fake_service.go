@@ -0,0 +1,287 @@
+// Package resttest provides an in-process fake downstream service for testing code built on the utils REST helpers.
+package resttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RecordedRequest struct holds a request received by FakeService
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Response struct is a scripted response
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Latency is waited before responding
+	Latency time.Duration
+	// Drop closes the connection without response, to simulate network failures
+	Drop bool
+}
+
+// Route struct holds the scripted responses of method + path, responses are served in order
+// and the last one is repeated
+type Route struct {
+	mu        sync.Mutex
+	responses []Response
+	served    int
+	latency   time.Duration
+}
+
+// FakeService struct is a fake downstream service built on httptest.Server
+type FakeService struct {
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	routes   map[string]*Route
+	requests []RecordedRequest
+}
+
+// NewFakeService function start new FakeService, caller must Close it
+func NewFakeService() *FakeService {
+	s := &FakeService{
+		routes: map[string]*Route{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close function stop server
+func (s *FakeService) Close() {
+	s.Server.Close()
+}
+
+// URL function return base url, e.g. http://127.0.0.1:12345
+func (s *FakeService) URL() string {
+	return s.Server.URL
+}
+
+// ServiceAddr function return "host:grpcPort" which BuildServiceUrl maps to this server (grpcPort + 1)
+func (s *FakeService) ServiceAddr() string {
+	host, port, _ := net.SplitHostPort(s.Server.Listener.Addr().String())
+	httpPort, _ := strconv.Atoi(port)
+	return net.JoinHostPort(host, strconv.Itoa(httpPort-1))
+}
+
+// Handle function return route of method and path, create it when missing
+func (s *FakeService) Handle(method, path string) *Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := routeKey(method, path)
+	route, ok := s.routes[key]
+	if !ok {
+		route = &Route{}
+		s.routes[key] = route
+	}
+	return route
+}
+
+// Respond function append response with raw body
+func (r *Route) Respond(statusCode int, body []byte, header ...string) *Route {
+	res := Response{StatusCode: statusCode, Header: http.Header{}, Body: body}
+	for i := 0; i+1 < len(header); i += 2 {
+		res.Header.Add(header[i], header[i+1])
+	}
+	return r.RespondWith(res)
+}
+
+// RespondJSON function append response with json body
+func (r *Route) RespondJSON(statusCode int, value interface{}) *Route {
+	body, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return r.Respond(statusCode, body, "Content-Type", "application/json")
+}
+
+// RespondWith function append response
+func (r *Route) RespondWith(res Response) *Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, res)
+	return r
+}
+
+// Fail function append n responses with statusCode, e.g. to test retry
+func (r *Route) Fail(n int, statusCode int) *Route {
+	for i := 0; i < n; i++ {
+		r.Respond(statusCode, []byte(http.StatusText(statusCode)))
+	}
+	return r
+}
+
+// Drop function append n responses which close the connection
+func (r *Route) Drop(n int) *Route {
+	for i := 0; i < n; i++ {
+		r.RespondWith(Response{Drop: true})
+	}
+	return r
+}
+
+// Delay function set latency of every request to route, including responses added later and
+// unscripted routes, Response.Latency takes precedence when set
+func (r *Route) Delay(latency time.Duration) *Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latency = latency
+	return r
+}
+
+// Served function return number of requests served by route
+func (r *Route) Served() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.served
+}
+
+func (r *Route) next() (Response, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.responses) == 0 {
+		return Response{Latency: r.latency}, false
+	}
+	index := r.served
+	if index >= len(r.responses) {
+		index = len(r.responses) - 1
+	}
+	r.served++
+	res := r.responses[index]
+	if res.Latency == 0 {
+		res.Latency = r.latency
+	}
+	return res, true
+}
+
+func (s *FakeService) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	route := s.routes[routeKey(req.Method, req.URL.Path)]
+	s.mu.Unlock()
+
+	if route == nil {
+		http.NotFound(w, req)
+		return
+	}
+	res, ok := route.next()
+	if res.Latency > 0 {
+		select {
+		case <-time.After(res.Latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if res.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	for key, values := range res.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	statusCode := res.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write(res.Body)
+}
+
+// Requests function return all recorded requests
+func (s *FakeService) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+// RequestsTo function return recorded requests of method and path
+func (s *FakeService) RequestsTo(method, path string) []RecordedRequest {
+	var result []RecordedRequest
+	for _, req := range s.Requests() {
+		if req.Method == method && req.Path == path {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// LastRequest function return last recorded request of method and path
+func (s *FakeService) LastRequest(method, path string) (RecordedRequest, bool) {
+	requests := s.RequestsTo(method, path)
+	if len(requests) == 0 {
+		return RecordedRequest{}, false
+	}
+	return requests[len(requests)-1], true
+}
+
+// Reset function remove routes and recorded requests
+func (s *FakeService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = map[string]*Route{}
+	s.requests = nil
+}
+
+// ExpectHeader function fail t when last request of method and path has no header key with value want
+func (s *FakeService) ExpectHeader(t testing.TB, method, path, key, want string) {
+	t.Helper()
+	req, ok := s.LastRequest(method, path)
+	if !ok {
+		t.Fatalf("no request to %v", routeKey(method, path))
+		return
+	}
+	if got := req.Header.Get(key); got != want {
+		t.Errorf("%v header %v = %q, want %q", routeKey(method, path), key, got, want)
+	}
+}
+
+// ExpectNoHeader function fail t when last request of method and path has header key
+func (s *FakeService) ExpectNoHeader(t testing.TB, method, path, key string) {
+	t.Helper()
+	req, ok := s.LastRequest(method, path)
+	if !ok {
+		t.Fatalf("no request to %v", routeKey(method, path))
+		return
+	}
+	if values := req.Header.Values(key); len(values) > 0 {
+		t.Errorf("%v header %v = %q, want none", routeKey(method, path), key, values)
+	}
+}
+
+func routeKey(method, path string) string {
+	return fmt.Sprintf("%v %v", strings.ToUpper(method), path)
+}
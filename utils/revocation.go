@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	//TokenRevokedError error
+	TokenRevokedError = status.Error(codes.Unauthenticated, "SYS.MSG.TOKEN_REVOKED_ERROR")
+
+	// revokedForever is used for file entries without time
+	revokedForever = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// DefaultRevocationRetention is how long a token revoked without expiry is kept when the list has no ttl
+const DefaultRevocationRetention = 24 * time.Hour
+
+// RevocationSubject struct identifies a token checked against revocations
+type RevocationSubject struct {
+	TokenId  string // jti
+	UserId   int64
+	DeviceId int64
+	IssuedAt time.Time // zero for tokens without iat
+}
+
+// RevocationSubjectFromClaims function return RevocationSubject of verified claims
+func RevocationSubjectFromClaims(claims jwt.MapClaims) RevocationSubject {
+	tokenId, _ := ToString(claims["jti"])
+	subject := RevocationSubject{TokenId: tokenId}
+	if userId, err := ToInt64(claims["userId"]); err == nil {
+		subject.UserId = userId
+	}
+	if deviceId, err := ToInt64(claims["deviceId"]); err == nil {
+		subject.DeviceId = deviceId
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		subject.IssuedAt = iat.Time
+	}
+	return subject
+}
+
+// RevocationChecker interface tell whether a token is revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, subject RevocationSubject) (bool, error)
+}
+
+// MemoryRevocationList struct is an in-memory RevocationChecker.
+// Revoking a user or device revokes every token issued before or in the same second, entries are kept for ttl
+// which must be at least the access token lifetime
+type MemoryRevocationList struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	tokens  map[string]time.Time // jti -> token expiry
+	users   map[int64]time.Time  // user id -> revoked at
+	devices map[int64]time.Time  // device id -> revoked at
+}
+
+// NewMemoryRevocationList function create new MemoryRevocationList
+func NewMemoryRevocationList(ttl time.Duration) *MemoryRevocationList {
+	return &MemoryRevocationList{
+		ttl:     ttl,
+		tokens:  map[string]time.Time{},
+		users:   map[int64]time.Time{},
+		devices: map[int64]time.Time{},
+	}
+}
+
+// RevokeToken function revoke token jti until its expiry, zero expiresAt keeps it for ttl
+// (DefaultRevocationRetention when ttl is 0)
+func (l *MemoryRevocationList) RevokeToken(tokenId string, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		retention := l.ttl
+		if retention <= 0 {
+			retention = DefaultRevocationRetention
+		}
+		expiresAt = time.Now().Add(retention)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeExpired()
+	l.tokens[tokenId] = expiresAt
+}
+
+// RevokeUser function revoke every token of user issued up to now, e.g. logout everywhere
+func (l *MemoryRevocationList) RevokeUser(userId int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeExpired()
+	l.users[userId] = revocationTime()
+}
+
+// RevokeDevice function revoke every token of device issued up to now, e.g. device removal
+func (l *MemoryRevocationList) RevokeDevice(deviceId int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeExpired()
+	l.devices[deviceId] = revocationTime()
+}
+
+// replace function swap all entries, used by FileRevocationList
+func (l *MemoryRevocationList) replace(tokens map[string]time.Time, users, devices map[int64]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = tokens
+	l.users = users
+	l.devices = devices
+}
+
+func (l *MemoryRevocationList) removeExpired() {
+	now := time.Now()
+	for id, expiresAt := range l.tokens {
+		if now.After(expiresAt) {
+			delete(l.tokens, id)
+		}
+	}
+	if l.ttl <= 0 {
+		return
+	}
+	for id, revokedAt := range l.users {
+		if now.After(revokedAt.Add(l.ttl)) {
+			delete(l.users, id)
+		}
+	}
+	for id, revokedAt := range l.devices {
+		if now.After(revokedAt.Add(l.ttl)) {
+			delete(l.devices, id)
+		}
+	}
+}
+
+// revocationTime function return now at full precision, tokens with iat not after it are revoked.
+// iat has second precision, so a token issued in the same second after the revocation is revoked too
+func revocationTime() time.Time {
+	return time.Now()
+}
+
+// issuedNotAfter function return true when a token issued at iat is covered by a revocation at revokedAt
+func issuedNotAfter(iat, revokedAt time.Time) bool {
+	return !iat.After(revokedAt)
+}
+
+func (l *MemoryRevocationList) IsRevoked(ctx context.Context, subject RevocationSubject) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if subject.TokenId != "" {
+		if _, ok := l.tokens[subject.TokenId]; ok {
+			return true, nil
+		}
+	}
+	if revokedAt, ok := l.users[subject.UserId]; ok && subject.UserId != 0 && issuedNotAfter(subject.IssuedAt, revokedAt) {
+		return true, nil
+	}
+	if revokedAt, ok := l.devices[subject.DeviceId]; ok && subject.DeviceId != 0 && issuedNotAfter(subject.IssuedAt, revokedAt) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// revocationEntry struct is an entry of revocation file, times are unix seconds
+type revocationEntry struct {
+	Id        string `mapstructure:"id"`
+	ExpiresAt int64  `mapstructure:"expiresAt"`
+	RevokedAt int64  `mapstructure:"revokedAt"`
+}
+
+// FileRevocationList struct is a RevocationChecker loaded from a config file, reloaded when the file changes.
+// File format (yaml, json, toml...):
+//
+//	revokedTokens:
+//	  - id: <jti>
+//	    expiresAt: 1767225600
+//	revokedUsers:
+//	  - id: 15
+//	    revokedAt: 1767139200
+//	revokedDevices:
+//	  - id: 7
+//	    revokedAt: 1767139200
+type FileRevocationList struct {
+	list   *MemoryRevocationList
+	config *viper.Viper
+}
+
+// NewFileRevocationList function load file and watch it for changes
+func NewFileRevocationList(filePath string) (*FileRevocationList, error) {
+	l := &FileRevocationList{
+		list:   NewMemoryRevocationList(0),
+		config: viper.New(),
+	}
+	l.config.SetConfigFile(filePath)
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	l.config.OnConfigChange(func(e fsnotify.Event) {
+		if err := l.load(); err != nil {
+			Logger().Error("FileRevocationList reload error", "file", e.Name, "error", err)
+		}
+	})
+	l.config.WatchConfig()
+	return l, nil
+}
+
+func (l *FileRevocationList) load() error {
+	if err := l.config.ReadInConfig(); err != nil {
+		return err
+	}
+	var tokenEntries, userEntries, deviceEntries []revocationEntry
+	if err := l.config.UnmarshalKey("revokedTokens", &tokenEntries); err != nil {
+		return err
+	}
+	if err := l.config.UnmarshalKey("revokedUsers", &userEntries); err != nil {
+		return err
+	}
+	if err := l.config.UnmarshalKey("revokedDevices", &deviceEntries); err != nil {
+		return err
+	}
+
+	tokens := make(map[string]time.Time, len(tokenEntries))
+	for _, entry := range tokenEntries {
+		expiresAt := time.Unix(entry.ExpiresAt, 0)
+		if entry.ExpiresAt == 0 {
+			expiresAt = revokedForever
+		}
+		tokens[entry.Id] = expiresAt
+	}
+	users, err := revocationTimes(userEntries)
+	if err != nil {
+		return err
+	}
+	devices, err := revocationTimes(deviceEntries)
+	if err != nil {
+		return err
+	}
+	l.list.replace(tokens, users, devices)
+	return nil
+}
+
+// revocationTimes function return revokedAt by numeric id, zero revokedAt revokes every token
+func revocationTimes(entries []revocationEntry) (map[int64]time.Time, error) {
+	result := make(map[int64]time.Time, len(entries))
+	for _, entry := range entries {
+		id, err := ToInt64(entry.Id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid revocation id %v", entry.Id)
+		}
+		revokedAt := time.Unix(entry.RevokedAt, 0)
+		if entry.RevokedAt == 0 {
+			revokedAt = revokedForever
+		}
+		result[id] = revokedAt
+	}
+	return result, nil
+}
+
+func (l *FileRevocationList) IsRevoked(ctx context.Context, subject RevocationSubject) (bool, error) {
+	return l.list.IsRevoked(ctx, subject)
+}
+
+// checkRevocation function return TokenRevokedError when claims are revoked by checker
+func checkRevocation(ctx context.Context, checker RevocationChecker, claims jwt.MapClaims) error {
+	if checker == nil {
+		return nil
+	}
+	revoked, err := checker.IsRevoked(ctx, RevocationSubjectFromClaims(claims))
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return TokenRevokedError
+	}
+	return nil
+}
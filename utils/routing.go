@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+// MethodRoute struct match gRPC methods and rewrite or redirect them, match fields are path.Match patterns
+// ("core.v1", "core.*", "*"), empty matches everything
+type MethodRoute struct {
+	// Package of the service, e.g. "core.v1"
+	Package string `mapstructure:"package"`
+	// Service name without package, e.g. "UserService"
+	Service string `mapstructure:"service"`
+	// Method name, e.g. "GetUser"
+	Method string `mapstructure:"method"`
+	// TargetPackage replaces the package, empty keeps it
+	TargetPackage string `mapstructure:"targetPackage"`
+	// TargetService replaces the service name, empty keeps it
+	TargetService string `mapstructure:"targetService"`
+	// Host sends matched calls to a connection of RouteTable Factory
+	Host string `mapstructure:"host"`
+	// Conn sends matched calls to this connection, takes precedence over Host
+	Conn *grpc.ClientConn `mapstructure:"-"`
+}
+
+// RouteTable struct holds MethodRoute, the first matching route wins
+type RouteTable struct {
+	mu     sync.RWMutex
+	routes []MethodRoute
+	// Factory dials Host of routes, DefaultRemoteConnFactory when nil
+	Factory *RemoteConnFactory
+}
+
+// NewRouteTable function create new RouteTable
+func NewRouteTable(routes ...MethodRoute) *RouteTable {
+	table := &RouteTable{}
+	for _, route := range routes {
+		table.Add(route)
+	}
+	return table
+}
+
+// LoadRouteTableFromFile function load routes from a config file (yaml, json, toml...):
+//
+//	routes:
+//	  - package: core.v1
+//	    service: UserService
+//	    targetPackage: core.v2
+//	  - package: report.*
+//	    host: report-v2:50051
+func LoadRouteTableFromFile(filePath string) (*RouteTable, error) {
+	config := viper.New()
+	config.SetConfigFile(filePath)
+	if err := config.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var routes []MethodRoute
+	if err := config.UnmarshalKey("routes", &routes); err != nil {
+		return nil, err
+	}
+	for index, route := range routes {
+		if route.TargetPackage == "" && route.TargetService == "" && route.Host == "" {
+			return nil, fmt.Errorf("route file %v: route %v has no targetPackage, targetService or host", filePath, index)
+		}
+	}
+	return NewRouteTable(routes...), nil
+}
+
+// Add function append route, routes added first take precedence
+func (t *RouteTable) Add(route MethodRoute) *RouteTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append(t.routes, route)
+	return t
+}
+
+// Route function return rewritten full method and connection of matching route,
+// conn is nil when the call stays on its own connection
+func (t *RouteTable) Route(fullMethod string) (string, *grpc.ClientConn, error) {
+	pkg, service, method, ok := splitFullMethod(fullMethod)
+	if !ok {
+		return fullMethod, nil, nil
+	}
+	t.mu.RLock()
+	route, found := t.match(pkg, service, method)
+	t.mu.RUnlock()
+	if !found {
+		return fullMethod, nil, nil
+	}
+
+	if route.TargetPackage != "" {
+		pkg = route.TargetPackage
+	}
+	if route.TargetService != "" {
+		service = route.TargetService
+	}
+	fullMethod = joinFullMethod(pkg, service, method)
+
+	conn := route.Conn
+	if conn == nil && route.Host != "" {
+		factory := t.Factory
+		if factory == nil {
+			factory = DefaultRemoteConnFactory
+		}
+		var err error
+		if conn, err = factory.Conn(route.Host); err != nil {
+			return fullMethod, nil, err
+		}
+	}
+	return fullMethod, conn, nil
+}
+
+func (t *RouteTable) match(pkg, service, method string) (MethodRoute, bool) {
+	for _, route := range t.routes {
+		if matchRoutePattern(route.Package, pkg) && matchRoutePattern(route.Service, service) && matchRoutePattern(route.Method, method) {
+			return route, true
+		}
+	}
+	return MethodRoute{}, false
+}
+
+// RouteClientUnary function return client interceptor routing calls by table,
+// calls routed to another connection go through the interceptors of that connection
+func RouteClientUnary(table *RouteTable) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		method, conn, err := table.Route(method)
+		if err != nil {
+			return err
+		}
+		if conn != nil && conn != cc {
+			return conn.Invoke(ctx, method, req, reply, opts...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// RouteClientStream function return client stream interceptor routing calls by table
+func RouteClientStream(table *RouteTable) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		method, conn, err := table.Route(method)
+		if err != nil {
+			return nil, err
+		}
+		if conn != nil && conn != cc {
+			return conn.NewStream(ctx, desc, method, opts...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// legacyMethodRewrite function return rewrite of the deprecated package helpers: every occurrence of test
+// in the full method is replaced, so partial package ("core" for "core.v1") and service names match too
+func legacyMethodRewrite(test, replaceWith string) func(string) string {
+	return func(method string) string {
+		if test == "" {
+			return method
+		}
+		return strings.ReplaceAll(method, test, replaceWith)
+	}
+}
+
+// legacyRewriteClientUnary function return client interceptor applying legacyMethodRewrite
+func legacyRewriteClientUnary(test, replaceWith string) grpc.UnaryClientInterceptor {
+	rewrite := legacyMethodRewrite(test, replaceWith)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(ctx, rewrite(method), req, reply, cc, opts...)
+	}
+}
+
+// legacyRewriteClientStream function return client stream interceptor applying legacyMethodRewrite
+func legacyRewriteClientStream(test, replaceWith string) grpc.StreamClientInterceptor {
+	rewrite := legacyMethodRewrite(test, replaceWith)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, rewrite(method), opts...)
+	}
+}
+
+// splitFullMethod function split "/package.Service/Method", package is empty for services without one
+func splitFullMethod(fullMethod string) (pkg, service, method string, ok bool) {
+	fullService, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok || fullService == "" || method == "" {
+		return "", "", "", false
+	}
+	if index := strings.LastIndex(fullService, "."); index >= 0 {
+		return fullService[:index], fullService[index+1:], method, true
+	}
+	return "", fullService, method, true
+}
+
+func joinFullMethod(pkg, service, method string) string {
+	if pkg == "" {
+		return "/" + service + "/" + method
+	}
+	return "/" + pkg + "." + service + "/" + method
+}
+
+func matchRoutePattern(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
@@ -0,0 +1,406 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// ServiceResolverScheme is the gRPC target scheme resolved by the global resolver
+	ServiceResolverScheme = "svc"
+	// DefaultHttpPortOffset is the "grpcPort+1" convention of BuildServiceUrl
+	DefaultHttpPortOffset = 1
+	// DefaultServiceResolverCacheTTL is how long RoundRobinServiceResolver reuses endpoints of a remote resolver
+	DefaultServiceResolverCacheTTL = 30 * time.Second
+	// DefaultServiceResolverTimeout bounds one lookup of RoundRobinServiceResolver
+	DefaultServiceResolverTimeout = 2 * time.Second
+)
+
+var (
+	ErrServiceNotFound = errors.New("service not found")
+
+	// globalServiceResolver is used by BuildServiceUrl and MakeRemoteConn, nil keeps "host:grpcPort" addressing
+	globalServiceResolver atomic.Pointer[RoundRobinServiceResolver]
+)
+
+// SetServiceResolver function set resolver used by BuildServiceUrl and MakeRemoteConn, nil to disable
+func SetServiceResolver(serviceResolver ServiceResolver) {
+	if serviceResolver == nil {
+		globalServiceResolver.Store(nil)
+		return
+	}
+	globalServiceResolver.Store(NewRoundRobinServiceResolver(serviceResolver))
+}
+
+// GetServiceResolver function return current resolver, nil when not set
+func GetServiceResolver() *RoundRobinServiceResolver {
+	return globalServiceResolver.Load()
+}
+
+// ServiceEndpoint struct holds one instance of a service
+type ServiceEndpoint struct {
+	Host     string `json:"host" mapstructure:"host"`
+	GrpcPort int    `json:"grpcPort" mapstructure:"grpcPort"`
+	HttpPort int    `json:"httpPort" mapstructure:"httpPort"` // 0: GrpcPort + DefaultHttpPortOffset
+}
+
+// GrpcAddr function return host:grpcPort
+func (e ServiceEndpoint) GrpcAddr() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.GrpcPort))
+}
+
+// HttpAddr function return host:httpPort
+func (e ServiceEndpoint) HttpAddr() string {
+	httpPort := e.HttpPort
+	if httpPort == 0 {
+		httpPort = e.GrpcPort + DefaultHttpPortOffset
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(httpPort))
+}
+
+// ServiceResolver interface resolve a service name to its endpoints
+type ServiceResolver interface {
+	Resolve(ctx context.Context, service string) ([]ServiceEndpoint, error)
+}
+
+// StaticServiceResolver struct resolve service from a fixed map
+type StaticServiceResolver struct {
+	mu        sync.RWMutex
+	endpoints map[string][]ServiceEndpoint
+}
+
+// NewStaticServiceResolver function create new StaticServiceResolver
+func NewStaticServiceResolver(endpoints map[string][]ServiceEndpoint) *StaticServiceResolver {
+	r := &StaticServiceResolver{}
+	r.Set(endpoints)
+	return r
+}
+
+// Set function replace all endpoints
+func (r *StaticServiceResolver) Set(endpoints map[string][]ServiceEndpoint) {
+	copied := make(map[string][]ServiceEndpoint, len(endpoints))
+	for service, list := range endpoints {
+		copied[service] = append([]ServiceEndpoint(nil), list...)
+	}
+	r.mu.Lock()
+	r.endpoints = copied
+	r.mu.Unlock()
+}
+
+func (r *StaticServiceResolver) local() {}
+
+func (r *StaticServiceResolver) Resolve(ctx context.Context, service string) ([]ServiceEndpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	endpoints := r.endpoints[service]
+	if len(endpoints) == 0 {
+		return nil, ErrServiceNotFound
+	}
+	return append([]ServiceEndpoint(nil), endpoints...), nil
+}
+
+// DnsSrvServiceResolver struct resolve service by DNS SRV record _service._proto.domain
+type DnsSrvServiceResolver struct {
+	Proto string // default "tcp"
+	// Domain is appended to service when service has no dot, without Domain such services are not found
+	Domain   string
+	Resolver *net.Resolver
+}
+
+// NewDnsSrvServiceResolver function create new DnsSrvServiceResolver
+func NewDnsSrvServiceResolver(domain string) *DnsSrvServiceResolver {
+	return &DnsSrvServiceResolver{
+		Proto:    "tcp",
+		Domain:   domain,
+		Resolver: net.DefaultResolver,
+	}
+}
+
+func (r *DnsSrvServiceResolver) Resolve(ctx context.Context, service string) ([]ServiceEndpoint, error) {
+	name := service
+	if !strings.Contains(name, ".") {
+		if r.Domain == "" {
+			return nil, fmt.Errorf("%w: DnsSrvServiceResolver has no Domain for %v", ErrServiceNotFound, service)
+		}
+		proto := r.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		name = fmt.Sprintf("_%v._%v.%v", service, proto, r.Domain)
+	}
+	netResolver := r.Resolver
+	if netResolver == nil {
+		netResolver = net.DefaultResolver
+	}
+	_, records, err := netResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, ErrServiceNotFound
+		}
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrServiceNotFound
+	}
+
+	endpoints := make([]ServiceEndpoint, 0, len(records))
+	for _, record := range records {
+		endpoints = append(endpoints, ServiceEndpoint{
+			Host:     strings.TrimSuffix(record.Target, "."),
+			GrpcPort: int(record.Port),
+		})
+	}
+	return endpoints, nil
+}
+
+// FileServiceResolver struct resolve service from a config file, reloaded when the file changes.
+// File format (yaml, json, toml...):
+//
+//	services:
+//	  core:
+//	    - host: 10.0.0.1
+//	      grpcPort: 9000
+type FileServiceResolver struct {
+	static *StaticServiceResolver
+	config *viper.Viper
+}
+
+// NewFileServiceResolver function load file and watch it for changes
+func NewFileServiceResolver(filePath string) (*FileServiceResolver, error) {
+	r := &FileServiceResolver{
+		static: NewStaticServiceResolver(nil),
+		config: viper.New(),
+	}
+	r.config.SetConfigFile(filePath)
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	r.config.OnConfigChange(func(e fsnotify.Event) {
+		if err := r.load(); err != nil {
+			Logger().Error("FileServiceResolver reload error", "file", e.Name, "error", err)
+		}
+	})
+	r.config.WatchConfig()
+	return r, nil
+}
+
+func (r *FileServiceResolver) load() error {
+	if err := r.config.ReadInConfig(); err != nil {
+		return err
+	}
+	var endpoints map[string][]ServiceEndpoint
+	if err := r.config.UnmarshalKey("services", &endpoints); err != nil {
+		return err
+	}
+	r.static.Set(endpoints)
+	return nil
+}
+
+func (r *FileServiceResolver) local() {}
+
+func (r *FileServiceResolver) Resolve(ctx context.Context, service string) ([]ServiceEndpoint, error) {
+	return r.static.Resolve(ctx, service)
+}
+
+// localServiceResolver interface is implemented by in-memory resolvers, which are not cached
+type localServiceResolver interface {
+	local()
+}
+
+// RoundRobinServiceResolver struct wrap a resolver, rotate endpoints on every resolve.
+// Lookups of remote resolvers (e.g. DNS SRV) are bounded by Timeout and cached for CacheTTL,
+// a failed refresh keeps the cached endpoints
+type RoundRobinServiceResolver struct {
+	ServiceResolver
+	// CacheTTL default DefaultServiceResolverCacheTTL
+	CacheTTL time.Duration
+	// Timeout default DefaultServiceResolverTimeout
+	Timeout time.Duration
+
+	counters sync.Map // service -> *uint64
+	cache    sync.Map // service -> *serviceCacheEntry
+}
+
+type serviceCacheEntry struct {
+	endpoints []ServiceEndpoint
+	err       error
+	expiresAt time.Time
+}
+
+// NewRoundRobinServiceResolver function create new RoundRobinServiceResolver
+func NewRoundRobinServiceResolver(serviceResolver ServiceResolver) *RoundRobinServiceResolver {
+	return &RoundRobinServiceResolver{ServiceResolver: serviceResolver}
+}
+
+// Resolve function return endpoints of service, cached for remote resolvers
+func (r *RoundRobinServiceResolver) Resolve(ctx context.Context, service string) ([]ServiceEndpoint, error) {
+	if _, ok := r.ServiceResolver.(localServiceResolver); ok {
+		return r.ServiceResolver.Resolve(ctx, service)
+	}
+	now := time.Now()
+	value, cached := r.cache.Load(service)
+	if cached {
+		if entry := value.(*serviceCacheEntry); now.Before(entry.expiresAt) {
+			return append([]ServiceEndpoint(nil), entry.endpoints...), entry.err
+		}
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultServiceResolverTimeout
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	endpoints, err := r.ServiceResolver.Resolve(lookupCtx, service)
+	ttl := r.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultServiceResolverCacheTTL
+	}
+	if err != nil && !errors.Is(err, ErrServiceNotFound) {
+		if cached && value.(*serviceCacheEntry).err == nil {
+			// keep serving the cached endpoints for another ttl instead of retrying on every call
+			Logger().WarnContext(ctx, "service resolve error, using cached endpoints", "service", service, "error", err)
+			stale := value.(*serviceCacheEntry).endpoints
+			r.cache.Store(service, &serviceCacheEntry{endpoints: stale, expiresAt: now.Add(ttl)})
+			return append([]ServiceEndpoint(nil), stale...), nil
+		}
+		return nil, err
+	}
+	r.cache.Store(service, &serviceCacheEntry{endpoints: endpoints, err: err, expiresAt: now.Add(ttl)})
+	return append([]ServiceEndpoint(nil), endpoints...), err
+}
+
+// Next function return next endpoint of service
+func (r *RoundRobinServiceResolver) Next(ctx context.Context, service string) (ServiceEndpoint, error) {
+	endpoints, err := r.Resolve(ctx, service)
+	if err != nil {
+		return ServiceEndpoint{}, err
+	}
+	counter, _ := r.counters.LoadOrStore(service, new(uint64))
+	index := atomic.AddUint64(counter.(*uint64), 1) - 1
+	return endpoints[index%uint64(len(endpoints))], nil
+}
+
+// resolveServiceHttpAddr function return host:httpPort of service name using the global resolver
+func resolveServiceHttpAddr(ctx context.Context, service string) (string, bool) {
+	serviceResolver := globalServiceResolver.Load()
+	if serviceResolver == nil || strings.Contains(service, ":") {
+		return "", false
+	}
+	endpoint, err := serviceResolver.Next(ctx, service)
+	if err != nil {
+		return "", false
+	}
+	return endpoint.HttpAddr(), true
+}
+
+// ServiceGrpcDialOptions function return gRPC target and dial options for remoteHost,
+// service names known by the global resolver are balanced round-robin over all endpoints
+func ServiceGrpcDialOptions(remoteHost string) (string, []grpc.DialOption) {
+	target, builder := serviceGrpcTarget(remoteHost)
+	if builder == nil {
+		return target, nil
+	}
+	return target, []grpc.DialOption{
+		grpc.WithResolvers(builder),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`),
+	}
+}
+
+// serviceGrpcTarget function return gRPC target of remoteHost and resolver builder, nil builder for plain addresses
+func serviceGrpcTarget(remoteHost string) (string, resolver.Builder) {
+	serviceResolver := globalServiceResolver.Load()
+	if serviceResolver == nil || strings.Contains(remoteHost, ":") {
+		return remoteHost, nil
+	}
+	if _, err := serviceResolver.Resolve(context.Background(), remoteHost); err != nil {
+		return remoteHost, nil
+	}
+	return fmt.Sprintf("%v:///%v", ServiceResolverScheme, remoteHost), &grpcResolverBuilder{serviceResolver: serviceResolver}
+}
+
+// grpcResolverBuilder struct adapt ServiceResolver to gRPC resolver
+type grpcResolverBuilder struct {
+	serviceResolver ServiceResolver
+}
+
+func (b *grpcResolverBuilder) Scheme() string {
+	return ServiceResolverScheme
+}
+
+func (b *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &grpcServiceResolver{
+		service:         strings.TrimPrefix(target.Endpoint(), "/"),
+		serviceResolver: b.serviceResolver,
+		cc:              cc,
+		resolveNow:      make(chan struct{}, 1),
+		closed:          make(chan struct{}),
+	}
+	r.resolve()
+	go r.watch()
+	return r, nil
+}
+
+// grpcServiceResolver struct re-resolves periodically so file changes reach open connections
+type grpcServiceResolver struct {
+	service         string
+	serviceResolver ServiceResolver
+	cc              resolver.ClientConn
+	resolveNow      chan struct{}
+	closed          chan struct{}
+	closeOnce       sync.Once
+}
+
+func (r *grpcServiceResolver) resolve() {
+	endpoints, err := r.serviceResolver.Resolve(context.Background(), r.service)
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	addresses := make([]resolver.Address, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, resolver.Address{Addr: endpoint.GrpcAddr()})
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addresses})
+}
+
+func (r *grpcServiceResolver) watch() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+		case <-r.resolveNow:
+		}
+		r.resolve()
+	}
+}
+
+func (r *grpcServiceResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *grpcServiceResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+}
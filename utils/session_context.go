@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// SessionContextHeader carries the encoded session context, it wins over the token suffix
+	SessionContextHeader = "x-session-context"
+	// SessionContextVersion is the prefix of signed session contexts, "v1.<kid>.<payload>.<signature>"
+	SessionContextVersion = "v1"
+
+	tokenSuffixSplitter = "|||"
+)
+
+var (
+	//SessionContextInvalidError error
+	SessionContextInvalidError = status.Error(codes.PermissionDenied, "SYS.MSG.SESSION_CONTEXT_INVALID_ERROR")
+	//SessionContextExpiredError error
+	SessionContextExpiredError = status.Error(codes.PermissionDenied, "SYS.MSG.SESSION_CONTEXT_EXPIRED_ERROR")
+)
+
+// SessionContext struct holds company, branch and department selected by the user
+type SessionContext struct {
+	CompanyId    int64
+	BranchId     int64
+	DepartmentId int64
+	// DiffHour is DiffHourNil when not set
+	DiffHour float64
+}
+
+// sessionContextPayload struct is the signed part of session context, bound to a user
+type sessionContextPayload struct {
+	UserId       int64    `json:"uid"`
+	CompanyId    int64    `json:"cid,omitempty"`
+	BranchId     int64    `json:"bid,omitempty"`
+	DepartmentId int64    `json:"did,omitempty"`
+	DiffHour     *float64 `json:"dh,omitempty"`
+	IssuedAt     int64    `json:"iat"`
+	ExpiresAt    int64    `json:"exp,omitempty"`
+}
+
+// SessionContextCodec struct encode and verify signed session contexts with HMAC-SHA256
+type SessionContextCodec struct {
+	// Keys maps key id to secret, old keys are kept to verify contexts signed before rotation
+	Keys map[string][]byte
+	// CurrentKeyId is the key used by Encode
+	CurrentKeyId string
+	// ExpiresIn is the lifetime of encoded contexts, 0 for no expiry
+	ExpiresIn time.Duration
+	// RejectLegacy rejects old unsigned "company|branch|department|diffHour" suffix, once every client is migrated
+	RejectLegacy bool
+}
+
+// SessionContextCodec global instance, nil accepts legacy suffix only unless RejectLegacySessionContext is set
+var (
+	SessionContextCodecInstance *SessionContextCodec
+
+	// RejectLegacySessionContext rejects the unsigned legacy suffix while SessionContextCodecInstance is nil
+	RejectLegacySessionContext = false
+
+	legacySessionContextWarning sync.Once
+)
+
+// NewSessionContextCodec function create new SessionContextCodec
+func NewSessionContextCodec(currentKeyId string, keys map[string][]byte) *SessionContextCodec {
+	return &SessionContextCodec{
+		Keys:         keys,
+		CurrentKeyId: currentKeyId,
+	}
+}
+
+// Encode function return signed session context of user, sent in SessionContextHeader
+// or appended to the bearer token after "|||"
+func (c *SessionContextCodec) Encode(userId int64, sc SessionContext) (string, error) {
+	key, ok := c.Keys[c.CurrentKeyId]
+	if !ok {
+		return "", errors.New("SessionContextCodec current key is missing")
+	}
+	if strings.Contains(c.CurrentKeyId, ".") {
+		return "", errors.New("SessionContextCodec key id must not contain '.'")
+	}
+
+	now := time.Now()
+	payload := sessionContextPayload{
+		UserId:       userId,
+		CompanyId:    sc.CompanyId,
+		BranchId:     sc.BranchId,
+		DepartmentId: sc.DepartmentId,
+		IssuedAt:     now.Unix(),
+	}
+	if sc.DiffHour != DiffHourNil {
+		diffHour := sc.DiffHour
+		payload.DiffHour = &diffHour
+	}
+	if c.ExpiresIn > 0 {
+		payload.ExpiresAt = now.Add(c.ExpiresIn).Unix()
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signed := SessionContextVersion + "." + c.CurrentKeyId + "." + base64.RawURLEncoding.EncodeToString(data)
+	return signed + "." + signSessionContext(key, signed), nil
+}
+
+func signSessionContext(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Decode function verify session context of user, empty value return an empty context
+func (c *SessionContextCodec) Decode(userId int64, value string) (SessionContext, error) {
+	if value == "" {
+		return SessionContext{DiffHour: DiffHourNil}, nil
+	}
+	if !strings.HasPrefix(value, SessionContextVersion+".") {
+		if c.rejectLegacy() {
+			return SessionContext{}, SessionContextInvalidError
+		}
+		legacySessionContextWarning.Do(func() {
+			Logger().Warn("unsigned session context is deprecated, send a SessionContextCodec encoded context")
+		})
+		return parseLegacySessionContext(value), nil
+	}
+	if c == nil {
+		return SessionContext{}, SessionContextInvalidError
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return SessionContext{}, SessionContextInvalidError
+	}
+	key, ok := c.Keys[parts[1]]
+	if !ok {
+		return SessionContext{}, SessionContextInvalidError
+	}
+	signed := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(signSessionContext(key, signed))) {
+		return SessionContext{}, SessionContextInvalidError
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return SessionContext{}, SessionContextInvalidError
+	}
+	var payload sessionContextPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return SessionContext{}, SessionContextInvalidError
+	}
+	// a context signed for another user can not be replayed
+	if payload.UserId != userId {
+		return SessionContext{}, SessionContextInvalidError
+	}
+	if payload.ExpiresAt > 0 && time.Now().Unix() > payload.ExpiresAt {
+		return SessionContext{}, SessionContextExpiredError
+	}
+
+	sc := SessionContext{
+		CompanyId:    payload.CompanyId,
+		BranchId:     payload.BranchId,
+		DepartmentId: payload.DepartmentId,
+		DiffHour:     DiffHourNil,
+	}
+	if payload.DiffHour != nil {
+		sc.DiffHour = *payload.DiffHour
+	}
+	return sc, nil
+}
+
+// rejectLegacy function return true when unsigned legacy suffix is rejected
+func (c *SessionContextCodec) rejectLegacy() bool {
+	if c == nil {
+		return RejectLegacySessionContext
+	}
+	return c.RejectLegacy
+}
+
+// DecodeSessionContext function decode value with SessionContextCodecInstance
+func DecodeSessionContext(userId int64, value string) (SessionContext, error) {
+	return SessionContextCodecInstance.Decode(userId, value)
+}
+
+// parseLegacySessionContext function read unsigned "company|branch|department|diffHour" suffix
+func parseLegacySessionContext(suffix string) SessionContext {
+	sc := SessionContext{DiffHour: DiffHourNil}
+	parts := strings.Split(suffix, "|")
+	if len(parts) >= 3 {
+		sc.CompanyId, _ = ToInt64(parts[0])
+		sc.BranchId, _ = ToInt64(parts[1])
+		sc.DepartmentId, _ = ToInt64(parts[2])
+
+		if len(parts) >= 4 {
+			sc.DiffHour = ToFloat64WithDefault(parts[3], DiffHourNil)
+		}
+	}
+	return sc
+}
+
+// splitTokenSuffix function return token and session context appended after "|||"
+func splitTokenSuffix(accessToken string) (string, string) {
+	index := strings.Index(accessToken, tokenSuffixSplitter)
+	if index < 0 {
+		return accessToken, ""
+	}
+	return accessToken[:index], accessToken[index+len(tokenSuffixSplitter):]
+}
+
+// sessionContextValue function return SessionContextHeader of incoming metadata, or suffix
+func sessionContextValue(ctx context.Context, suffix string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(SessionContextHeader); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return suffix
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+	"sync"
+
+	"github.com/avct/uasurfer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	//AccountSuspendedError error
+	AccountSuspendedError = status.Error(codes.PermissionDenied, "SYS.MSG.ACCOUNT_SUSPENDED_ERROR")
+	//PasswordChangeRequiredError error
+	PasswordChangeRequiredError = status.Error(codes.FailedPrecondition, "SYS.MSG.PASSWORD_CHANGE_REQUIRED_ERROR")
+)
+
+// SessionState struct holds restrictions of a user or one of its devices
+type SessionState struct {
+	// Locked screen, the user must log in again on desktop browsers, other user agents are not affected
+	Locked bool
+	// Suspended account, every non public method is denied
+	Suspended bool
+	// ForcePasswordChange allows only the password change methods
+	ForcePasswordChange bool
+}
+
+// merge function return restrictions of both states
+func (s SessionState) merge(other SessionState) SessionState {
+	return SessionState{
+		Locked:              s.Locked || other.Locked,
+		Suspended:           s.Suspended || other.Suspended,
+		ForcePasswordChange: s.ForcePasswordChange || other.ForcePasswordChange,
+	}
+}
+
+// SessionStateProvider interface return state of user on device, deviceId is 0 when the token has none
+type SessionStateProvider interface {
+	SessionState(ctx context.Context, userId int64, deviceId int64) (SessionState, error)
+}
+
+type sessionDeviceKey struct {
+	userId   int64
+	deviceId int64
+}
+
+// MemorySessionStateProvider struct is a concurrency-safe in-memory SessionStateProvider,
+// a user state applies to all devices and is merged with the device state
+type MemorySessionStateProvider struct {
+	mu      sync.RWMutex
+	users   map[int64]SessionState
+	devices map[sessionDeviceKey]SessionState
+}
+
+// NewMemorySessionStateProvider function create new MemorySessionStateProvider
+func NewMemorySessionStateProvider() *MemorySessionStateProvider {
+	return &MemorySessionStateProvider{
+		users:   map[int64]SessionState{},
+		devices: map[sessionDeviceKey]SessionState{},
+	}
+}
+
+// SetUserState function set state of user on every device, zero state clears it
+func (p *MemorySessionStateProvider) SetUserState(userId int64, state SessionState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state == (SessionState{}) {
+		delete(p.users, userId)
+		return
+	}
+	p.users[userId] = state
+}
+
+// SetDeviceState function set state of user on one device, zero state clears it
+func (p *MemorySessionStateProvider) SetDeviceState(userId int64, deviceId int64, state SessionState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := sessionDeviceKey{userId: userId, deviceId: deviceId}
+	if state == (SessionState{}) {
+		delete(p.devices, key)
+		return
+	}
+	p.devices[key] = state
+}
+
+func (p *MemorySessionStateProvider) SessionState(ctx context.Context, userId int64, deviceId int64) (SessionState, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	state := p.users[userId]
+	if deviceId != 0 {
+		state = state.merge(p.devices[sessionDeviceKey{userId: userId, deviceId: deviceId}])
+	}
+	return state, nil
+}
+
+// checkSessionState function return error of restricted state, passwordChangeMethods are allowed on ForcePasswordChange
+// and Locked only applies to desktop browsers
+func checkSessionState(state SessionState, method string, passwordChangeMethods map[string]bool, isComputer bool) error {
+	switch {
+	case state.Suspended:
+		return AccountSuspendedError
+	case state.Locked && isComputer:
+		return NeedLogin
+	case state.ForcePasswordChange && !passwordChangeMethods[method]:
+		return PasswordChangeRequiredError
+	}
+	return nil
+}
+
+// isLockScreen function read the deprecated lockScreens map under LockScreensMutex
+func isLockScreen(lockScreens map[int64]bool, userId int64) bool {
+	if lockScreens == nil {
+		return false
+	}
+	LockScreensMutex.RLock()
+	defer LockScreensMutex.RUnlock()
+	return lockScreens[userId]
+}
+
+// incomingUserAgent function return user-agent of incoming metadata, empty when missing
+func incomingUserAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("user-agent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// isComputerUserAgent function return true for desktop browsers, false for missing user-agent
+func isComputerUserAgent(userAgent string) bool {
+	if userAgent == "" {
+		return false
+	}
+	return uasurfer.Parse(userAgent).DeviceType == uasurfer.DeviceComputer
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	SignedUrlKeyIdParam     = "kid"
+	SignedUrlExpiresParam   = "expires"
+	SignedUrlUserIdParam    = "uid"
+	SignedUrlIpParam        = "ip"
+	SignedUrlSignatureParam = "signature"
+)
+
+var (
+	//InvalidSignatureError error
+	InvalidSignatureError = status.Error(codes.PermissionDenied, "SYS.MSG.INVALID_SIGNATURE_ERROR")
+	//SignatureExpiredError error
+	SignatureExpiredError = status.Error(codes.PermissionDenied, "SYS.MSG.SIGNATURE_EXPIRED_ERROR")
+)
+
+// UrlSigner struct sign and verify urls with HMAC-SHA256
+type UrlSigner struct {
+	// Keys maps key id to secret, old keys are kept to verify urls signed before rotation
+	Keys map[string][]byte
+	// CurrentKeyId is the key used by SignURL
+	CurrentKeyId string
+	// AllowLegacyChecksum accepts old unsigned "checksum" urls (IsValidChecksum), for migration only
+	AllowLegacyChecksum bool
+	// TrustedProxies are ips or CIDRs (e.g. "10.0.0.0/8", "127.0.0.1") whose X-Forwarded-For and X-Real-IP
+	// headers are honoured for ip binding, empty uses the connection address only
+	TrustedProxies []string
+}
+
+// UrlSigner global instance
+var (
+	UrlSignerInstance *UrlSigner
+)
+
+// SignOptions struct
+type SignOptions struct {
+	// ExpiresIn is the url lifetime, required
+	ExpiresIn time.Duration
+	// UserId binds url to a logged in user, 0 to skip
+	UserId int64
+	// Ip binds url to a client ip, empty to skip
+	Ip string
+}
+
+// NewUrlSigner function create new UrlSigner
+func NewUrlSigner(currentKeyId string, keys map[string][]byte) *UrlSigner {
+	return &UrlSigner{
+		Keys:         keys,
+		CurrentKeyId: currentKeyId,
+	}
+}
+
+// SignURL function return rawUrl with kid, expires, optional uid / ip and signature params
+func (s *UrlSigner) SignURL(rawUrl string, opts SignOptions) (string, error) {
+	key, ok := s.Keys[s.CurrentKeyId]
+	if !ok {
+		return "", errors.New("UrlSigner current key is missing")
+	}
+	if opts.ExpiresIn <= 0 {
+		return "", errors.New("UrlSigner ExpiresIn is required")
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Del(SignedUrlSignatureParam)
+	query.Set(SignedUrlKeyIdParam, s.CurrentKeyId)
+	query.Set(SignedUrlExpiresParam, strconv.FormatInt(time.Now().Add(opts.ExpiresIn).Unix(), 10))
+	query.Del(SignedUrlUserIdParam)
+	if opts.UserId != 0 {
+		query.Set(SignedUrlUserIdParam, strconv.FormatInt(opts.UserId, 10))
+	}
+	query.Del(SignedUrlIpParam)
+	if opts.Ip != "" {
+		query.Set(SignedUrlIpParam, opts.Ip)
+	}
+
+	query.Set(SignedUrlSignatureParam, signUrl(key, u.Path, query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// signUrl function return base64url HMAC of path and sorted query without signature
+func signUrl(key []byte, path string, query url.Values) string {
+	values := url.Values{}
+	for k, v := range query {
+		if k != SignedUrlSignatureParam {
+			values[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%v?%v", path, values.Encode())))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyURL function check signature, expiry and bindings of rawUrl, userId / ip are those of the caller
+func (s *UrlSigner) VerifyURL(rawUrl string, userId int64, ip string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return InvalidSignatureError
+	}
+	query := u.Query()
+
+	signature := query.Get(SignedUrlSignatureParam)
+	if signature == "" {
+		if s.AllowLegacyChecksum && IsValidChecksumWithUrl(rawUrl) {
+			return nil
+		}
+		return InvalidSignatureError
+	}
+
+	key, ok := s.Keys[query.Get(SignedUrlKeyIdParam)]
+	if !ok {
+		return InvalidSignatureError
+	}
+	expected := signUrl(key, u.Path, query)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return InvalidSignatureError
+	}
+
+	expires, err := strconv.ParseInt(query.Get(SignedUrlExpiresParam), 10, 64)
+	if err != nil {
+		return InvalidSignatureError
+	}
+	if time.Now().Unix() > expires {
+		return SignatureExpiredError
+	}
+
+	if boundUser := query.Get(SignedUrlUserIdParam); boundUser != "" && boundUser != strconv.FormatInt(userId, 10) {
+		return InvalidSignatureError
+	}
+	if boundIp := query.Get(SignedUrlIpParam); boundIp != "" && boundIp != ip {
+		return InvalidSignatureError
+	}
+	return nil
+}
+
+// VerifyRequest function verify signed url of http request
+func (s *UrlSigner) VerifyRequest(r *http.Request) error {
+	var userId int64
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" && JwtManagerInstance != nil {
+		userId, _ = GetUserIDFromToken(token)
+	}
+	return s.VerifyURL(r.URL.String(), userId, s.requestClientIp(r))
+}
+
+// VerifyContext function verify signed url of "pattern" metadata, set by NewServeMux and MakePropagatedContext
+func (s *UrlSigner) VerifyContext(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return InvalidSignatureError
+	}
+	patterns := md.Get("pattern")
+	if len(patterns) == 0 {
+		return InvalidSignatureError
+	}
+
+	var userId int64
+	if JwtManagerInstance != nil {
+		if _, err := GetAccessToken(ctx); err == nil {
+			userId, _ = GetUserID(ctx)
+		}
+	}
+	return s.VerifyURL(patterns[0], userId, s.metadataClientIp(ctx, md))
+}
+
+// IsValidSignedRequest function verify http request with UrlSignerInstance
+func IsValidSignedRequest(r *http.Request) bool {
+	return UrlSignerInstance != nil && UrlSignerInstance.VerifyRequest(r) == nil
+}
+
+// IsValidSignedContext function verify gRPC context with UrlSignerInstance
+func IsValidSignedContext(ctx context.Context) bool {
+	return UrlSignerInstance != nil && UrlSignerInstance.VerifyContext(ctx) == nil
+}
+
+// requestClientIp function return client ip of r, forwarded headers are used only from TrustedProxies
+func (s *UrlSigner) requestClientIp(r *http.Request) string {
+	return s.clientIp(r.RemoteAddr, r.Header.Get("X-Real-IP"), r.Header.Values("X-Forwarded-For"))
+}
+
+// metadataClientIp function return client ip of gRPC peer, forwarded metadata is used only from TrustedProxies
+func (s *UrlSigner) metadataClientIp(ctx context.Context, md metadata.MD) string {
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	realIp := ""
+	if ips := md.Get("x-real-ip"); len(ips) > 0 {
+		realIp = ips[0]
+	}
+	return s.clientIp(remoteAddr, realIp, md.Get("x-forwarded-for"))
+}
+
+// clientIp function return remote ip, when remote is a trusted proxy return the nearest untrusted
+// X-Forwarded-For hop (walking right to left), then X-Real-IP
+func (s *UrlSigner) clientIp(remoteAddr, realIp string, forwardedFor []string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !s.isTrustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, value := range forwardedFor {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !s.isTrustedProxy(hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	if realIp = strings.TrimSpace(realIp); realIp != "" {
+		return realIp
+	}
+	return ip
+}
+
+// isTrustedProxy function check ip against TrustedProxies
+func (s *UrlSigner) isTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range s.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxyIp := net.ParseIP(proxy); proxyIp != nil && proxyIp.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+// TokenSource interface return the token attached to outgoing calls, empty to attach none
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc function adapt a function to TokenSource
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// IncomingTokenSource function return source forwarding the incoming user token, with its session context suffix
+func IncomingTokenSource() TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return "", nil
+		}
+		auths := md.Get("authorization")
+		if len(auths) == 0 {
+			return "", nil
+		}
+		return strings.Replace(auths[0], "Bearer ", "", 1), nil
+	})
+}
+
+// FallbackTokenSource function return source forwarding the incoming token, or the token of fallback
+// when there is none, e.g. background jobs
+func FallbackTokenSource(fallback TokenSource) TokenSource {
+	incoming := IncomingTokenSource()
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		token, err := incoming.Token(ctx)
+		if err != nil || token != "" || fallback == nil {
+			return token, err
+		}
+		return fallback.Token(ctx)
+	})
+}
+
+// StaticTokenSource function return source of a fixed token
+func StaticTokenSource(token string) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// CachedTokenSource struct cache token of Fetch and fetch a new one RefreshBefore its expiry
+type CachedTokenSource struct {
+	Fetch         func(ctx context.Context) (token string, expiresAt time.Time, err error)
+	RefreshBefore time.Duration
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewCachedTokenSource function create new CachedTokenSource, refreshBefore defaults to 1 minute
+func NewCachedTokenSource(fetch func(ctx context.Context) (string, time.Time, error), refreshBefore time.Duration) *CachedTokenSource {
+	if refreshBefore <= 0 {
+		refreshBefore = time.Minute
+	}
+	return &CachedTokenSource{
+		Fetch:         fetch,
+		RefreshBefore: refreshBefore,
+	}
+}
+
+// NewServiceTokenSource function return cached source of service tokens signed by manager, ttl must be positive
+func NewServiceTokenSource(manager *JwtManager, service string, scopes []string, ttl time.Duration) (*CachedTokenSource, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("NewServiceTokenSource: ttl must be positive, got %v", ttl)
+	}
+	return NewCachedTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		token, err := manager.GenerateServiceToken(service, scopes, ttl)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token, tokenExpiresAt(token), nil
+	}, ttl/5), nil
+}
+
+// tokenExpiresAt function return "exp" of a token signed by us, read without verification
+func tokenExpiresAt(token string) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err == nil {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			return exp.Time
+		}
+	}
+	// no exp: refresh on every call
+	return time.Now()
+}
+
+// Token function return cached token, a failed refresh keeps the old token until it expires
+func (s *CachedTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.token != "" && now.Before(s.expiresAt.Add(-s.RefreshBefore)) {
+		return s.token, nil
+	}
+	token, expiresAt, err := s.Fetch(ctx)
+	if err != nil {
+		if s.token != "" && now.Before(s.expiresAt) {
+			return s.token, nil
+		}
+		return "", err
+	}
+	s.token = token
+	s.expiresAt = expiresAt
+	return token, nil
+}